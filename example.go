@@ -19,6 +19,7 @@
 package main
 
 import (
+	"context"
 	"encoding/xml"
 	"flag"
 	"fmt"
@@ -32,23 +33,6 @@ import (
 	"golang.org/x/crypto/ssh"
 )
 
-type yangPush struct {
-	netconf.Notification
-	PushUpdate struct {
-		Content struct {
-			InnerXML []byte `xml:",innerxml"`
-		} `xml:"datastore-contents-xml"`
-	} `xml:"urn:ietf:params:xml:ns:yang:ietf-yang-push push-update"`
-}
-
-type establishSubscription struct {
-	XMLName     xml.Name `xml:"urn:ietf:params:xml:ns:yang:ietf-event-notifications establish-subscription"`
-	YangPush    string   `xml:"xmlns:yp,attr"`
-	Stream      string   `xml:"stream"`
-	XPathFilter string   `xml:"urn:ietf:params:xml:ns:yang:ietf-yang-push xpath-filter"`
-	Period      int      `xml:"urn:ietf:params:xml:ns:yang:ietf-yang-push period"`
-}
-
 func main() {
 	var address string
 	var username string
@@ -107,27 +91,30 @@ func main() {
 				Stream: &stream,
 			}
 			err = session.CallSimple(subscribe)
+			for err == nil {
+				push := &netconf.PushUpdate{}
+				if err = session.Receive(push); err == nil {
+					fmt.Printf("%s: %s\n\n", push.EventTime, push.Contents)
+				} else if _, ok := err.(xml.UnmarshalError); ok {
+					err = nil // Skip other notifications, e.g. replayComplete
+				}
+			}
 		} else {
-			establish := &establishSubscription{
-				YangPush:    "urn:ietf:params:xml:ns:yang:ietf-yang-push",
-				Stream:      "yp:yang-push",
-				XPathFilter: filter,
-				Period:      period * 100,
+			// YANG-Push subscriptions (RFC 8641) require a multiplexed session
+			session.Multiplex()
+			establish := netconf.NewPeriodicSubscription(netconf.DatastoreOperational, filter, time.Duration(period)*time.Second)
+			var subscription *netconf.Subscription
+			subscription, err = session.EstablishSubscription(context.Background(), establish)
+			if err == nil {
+				for notification := range subscription.Notifications() {
+					if push := netconf.ParsePushUpdate(notification); push != nil {
+						fmt.Printf("%s: %s\n\n", push.EventTime, push.Contents)
+					}
+				}
 			}
-			err = session.CallSimple(establish)
 		}
 		if err != nil {
 			log.Println(err.Error())
-		} else {
-			push := &yangPush{}
-			for {
-				err = session.Receive(push)
-				if err != nil {
-					log.Println(err.Error())
-				} else {
-					fmt.Printf("%s: %s\n\n", push.EventTime, push.PushUpdate.Content.InnerXML)
-				}
-			}
 		}
 
 		session.Close()
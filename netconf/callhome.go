@@ -0,0 +1,220 @@
+/**
+ * Copyright (c) 2019-2020 Cisco Systems
+ *
+ * Author: Steven Barth <stbarth@cisco.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package netconf
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// IANA-assigned ports for NETCONF Call Home (RFC 8071)
+const (
+	CallHomeSSHPort = 4334
+	CallHomeTLSPort = 4335
+)
+
+// DefaultHandshakeTimeout limits the SSH or TLS handshake of a device calling home unless configured otherwise
+const DefaultHandshakeTimeout = 30 * time.Second
+
+// CallHomeClient is a NETCONF client established by a device calling home
+type CallHomeClient struct {
+	Client
+	RemoteAddr  net.Addr
+	Fingerprint string // SSH host key (SHA256) or TLS certificate fingerprint
+}
+
+// NewSessionContext creates a new session on the connection of the device, aborting if the context ends first
+func (c *CallHomeClient) NewSessionContext(ctx context.Context) (*Session, error) {
+	return NewSessionContext(ctx, c.Client)
+}
+
+// CallHomeListener accepts NETCONF Call Home connections and runs the client role on them.
+// Handshakes run concurrently so that a stalling device does not delay others.
+type CallHomeListener struct {
+	// HandshakeTimeout limits the SSH or TLS handshake of each device, DefaultHandshakeTimeout if zero
+	HandshakeTimeout time.Duration
+
+	listener  net.Listener
+	handshake func(net.Conn) (*CallHomeClient, error)
+	start     sync.Once
+	results   chan callHomeResult
+	stopped   chan struct{} // Closed once the listener fails, err is set before
+	err       error
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+// callHomeResult is the outcome of the handshake with a device
+type callHomeResult struct {
+	client *CallHomeClient
+	err    error
+}
+
+func newCallHomeListener(listener net.Listener, handshake func(net.Conn) (*CallHomeClient, error)) *CallHomeListener {
+	return &CallHomeListener{
+		listener:  listener,
+		handshake: handshake,
+		results:   make(chan callHomeResult),
+		stopped:   make(chan struct{}),
+		closed:    make(chan struct{}),
+	}
+}
+
+// ListenCallHomeSSH listens for NETCONF over SSH Call Home connections on the given address
+func ListenCallHomeSSH(addr string, config *ssh.ClientConfig) (*CallHomeListener, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return NewCallHomeListenerSSH(listener, config), nil
+}
+
+// ListenCallHomeTLS listens for NETCONF over TLS Call Home connections on the given address
+func ListenCallHomeTLS(addr string, config *tls.Config) (*CallHomeListener, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return NewCallHomeListenerTLS(listener, config), nil
+}
+
+// NewCallHomeListenerSSH creates a NETCONF over SSH Call Home listener from an existing listener
+func NewCallHomeListenerSSH(listener net.Listener, config *ssh.ClientConfig) *CallHomeListener {
+	return newCallHomeListener(listener, func(conn net.Conn) (*CallHomeClient, error) {
+		client := &CallHomeClient{RemoteAddr: conn.RemoteAddr()}
+
+		// Record the host key while still honoring the configured verification
+		sshConfig := *config
+		if config.HostKeyCallback != nil {
+			sshConfig.HostKeyCallback = func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+				client.Fingerprint = ssh.FingerprintSHA256(key)
+				return config.HostKeyCallback(hostname, remote, key)
+			}
+		}
+
+		sshConn, chans, reqs, err := ssh.NewClientConn(conn, conn.RemoteAddr().String(), &sshConfig)
+		if err != nil {
+			return nil, err
+		}
+
+		client.Client = NewClientSSH(ssh.NewClient(sshConn, chans, reqs))
+		return client, nil
+	})
+}
+
+// NewCallHomeListenerTLS creates a NETCONF over TLS Call Home listener from an existing listener
+func NewCallHomeListenerTLS(listener net.Listener, config *tls.Config) *CallHomeListener {
+	return newCallHomeListener(listener, func(conn net.Conn) (*CallHomeClient, error) {
+		tlsConn := tls.Client(conn, config)
+		if err := tlsConn.Handshake(); err != nil {
+			return nil, err
+		}
+
+		client := &CallHomeClient{Client: NewClientTLS(tlsConn), RemoteAddr: conn.RemoteAddr()}
+		if chain := tlsConn.ConnectionState().PeerCertificates; len(chain) > 0 {
+			client.Fingerprint = TLSFingerprint(chain[0])
+		}
+		return client, nil
+	})
+}
+
+// Accept waits for the next device calling home and returns a client for it.
+// A device not completing the handshake in time is disconnected and an error is returned,
+// the listener stays usable unless it failed itself.
+func (l *CallHomeListener) Accept() (*CallHomeClient, error) {
+	l.start.Do(func() {
+		go l.run()
+	})
+
+	select {
+	case result := <-l.results:
+		return result.client, result.err
+	case <-l.stopped:
+	}
+
+	// Prefer handshakes which completed before the listener failed
+	select {
+	case result := <-l.results:
+		return result.client, result.err
+	default:
+		return nil, l.err
+	}
+}
+
+// run accepts connections until the listener fails and starts their handshakes
+func (l *CallHomeListener) run() {
+	for {
+		conn, err := l.listener.Accept()
+		if err != nil {
+			l.err = err
+			close(l.stopped)
+			return
+		}
+		go l.accept(conn)
+	}
+}
+
+// accept runs the handshake with a device and passes the outcome to Accept
+func (l *CallHomeListener) accept(conn net.Conn) {
+	timeout := l.HandshakeTimeout
+	if timeout == 0 {
+		timeout = DefaultHandshakeTimeout
+	}
+
+	var client *CallHomeClient
+	err := conn.SetDeadline(time.Now().Add(timeout))
+	if err == nil {
+		client, err = l.handshake(conn)
+	}
+	if err == nil {
+		err = conn.SetDeadline(time.Time{})
+	}
+	if err != nil && client != nil {
+		client.Close()
+		client = nil
+	} else if err != nil {
+		conn.Close()
+	}
+
+	select {
+	case l.results <- callHomeResult{client: client, err: err}:
+	case <-l.closed:
+		if client != nil {
+			client.Close()
+		}
+	}
+}
+
+// Addr returns the address the listener is bound to
+func (l *CallHomeListener) Addr() net.Addr {
+	return l.listener.Addr()
+}
+
+// Close the listener, already accepted clients stay open while those not yet returned by Accept are closed
+func (l *CallHomeListener) Close() error {
+	l.closeOnce.Do(func() {
+		close(l.closed)
+	})
+	return l.listener.Close()
+}
@@ -0,0 +1,101 @@
+/**
+ * Copyright (c) 2019-2020 Cisco Systems
+ *
+ * Author: Steven Barth <stbarth@cisco.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package netconf
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"net"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestCallHomeSSH(t *testing.T) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverConfig := &ssh.ServerConfig{NoClientAuth: true}
+	serverConfig.AddHostKey(signer)
+
+	server := NewServer()
+	defer server.Close()
+
+	listener, err := ListenCallHomeSSH("127.0.0.1:0", &ssh.ClientConfig{User: "user", HostKeyCallback: ssh.InsecureIgnoreHostKey()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	listener.HandshakeTimeout = time.Second
+
+	// A device not starting the handshake does not delay others and is disconnected after the timeout
+	start := time.Now()
+	stalled, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stalled.Close()
+
+	// The device connects to the listener and serves NETCONF as SSH server
+	go func() {
+		if conn, err := net.Dial("tcp", listener.Addr().String()); err == nil {
+			server.serveSSHConn(conn, serverConfig)
+		}
+	}()
+
+	client, err := listener.Accept()
+	if err != nil {
+		t.Fatal(err)
+	} else if elapsed := time.Since(start); elapsed >= listener.HandshakeTimeout {
+		t.Fatalf("Device delayed by stalled one for %v", elapsed)
+	}
+	defer client.Close()
+
+	if client.Fingerprint != ssh.FingerprintSHA256(signer.PublicKey()) {
+		t.Fatalf("Unexpected fingerprint %q", client.Fingerprint)
+	} else if client.RemoteAddr == nil {
+		t.Fatal("Remote address missing")
+	}
+
+	if _, err := listener.Accept(); err == nil {
+		t.Fatal("Handshake with stalled device succeeded")
+	} else if elapsed := time.Since(start); elapsed < listener.HandshakeTimeout || elapsed > 10*time.Second {
+		t.Fatalf("Handshake timed out after %v", elapsed)
+	}
+
+	// The handshake deadline, which has passed by now, no longer applies to the session
+	session, err := client.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	} else if err = session.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Accept fails once the listener is closed
+	listener.Close()
+	if _, err := listener.Accept(); err == nil {
+		t.Fatal("Accept succeeded on closed listener")
+	}
+}
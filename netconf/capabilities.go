@@ -0,0 +1,157 @@
+/**
+ * Copyright (c) 2019-2020 Cisco Systems
+ *
+ * Author: Steven Barth <stbarth@cisco.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package netconf
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Module is a YANG module announced as a capability as of RFC 6020 Section 5.6.4
+type Module struct {
+	Name       string
+	Namespace  string
+	Revision   string // YYYY-MM-DD or empty if unknown
+	Features   []string
+	Deviations []string // Names of modules containing deviations
+}
+
+// HasFeature returns true if the module announced support for the given feature
+func (m *Module) HasFeature(feature string) bool {
+	for _, f := range m.Features {
+		if f == feature {
+			return true
+		}
+	}
+	return false
+}
+
+// HasRevision returns true if the module has at least the given revision, an empty one matches any
+func (m *Module) HasRevision(minRevision string) bool {
+	return len(minRevision) == 0 || (len(m.Revision) > 0 && m.Revision >= minRevision)
+}
+
+// parseModule parses a capability announcing a YANG module, it returns nil for other capabilities
+func parseModule(namespace string, query string) *Module {
+	parameters, err := url.ParseQuery(strings.ReplaceAll(query, "&amp;", "&"))
+	if err != nil || len(parameters.Get("module")) == 0 {
+		return nil
+	}
+
+	return &Module{
+		Name:       parameters.Get("module"),
+		Namespace:  namespace,
+		Revision:   parameters.Get("revision"),
+		Features:   splitList(parameters.Get("features")),
+		Deviations: splitList(parameters.Get("deviations")),
+	}
+}
+
+func splitList(list string) []string {
+	if len(list) == 0 {
+		return nil
+	}
+	return strings.Split(list, ",")
+}
+
+// parseModules returns the YANG modules of the given capabilities by name
+func parseModules(capabilities map[string]string) map[string]*Module {
+	modules := make(map[string]*Module)
+	for capability, query := range capabilities {
+		if module := parseModule(capability, query); module != nil {
+			modules[module.Name] = module
+		}
+	}
+	return modules
+}
+
+// splitCapabilityVersion splits a capability like urn:ietf:params:netconf:base:1.1 into its base and version
+func splitCapabilityVersion(capability string) (string, string) {
+	i := strings.LastIndexByte(capability, ':')
+	if i < 0 || compareVersions(capability[i+1:], "0") < 0 {
+		return capability, ""
+	}
+	return capability[:i], capability[i+1:]
+}
+
+// compareVersions compares dotted version numbers like 1.0 and 1.1 numerically.
+// It returns -1, 0 or 1 and -2 if any of them is not a valid version.
+func compareVersions(a string, b string) int {
+	partsA, partsB := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(partsA) || i < len(partsB); i++ {
+		var numA, numB uint64
+		var err error
+		if i < len(partsA) {
+			if numA, err = strconv.ParseUint(partsA[i], 10, 32); err != nil {
+				return -2
+			}
+		}
+		if i < len(partsB) {
+			if numB, err = strconv.ParseUint(partsB[i], 10, 32); err != nil {
+				return -2
+			}
+		}
+
+		if numA < numB {
+			return -1
+		} else if numA > numB {
+			return 1
+		}
+	}
+	return 0
+}
+
+// CapabilityVersion returns the highest announced version of a capability given without version,
+// e.g. "1.1" for urn:ietf:params:netconf:capability:validate or empty if it was not announced
+func (s *Session) CapabilityVersion(base string) string {
+	version := ""
+	for capability := range s.Capabilities {
+		if capabilityBase, capabilityVersion := splitCapabilityVersion(capability); capabilityBase == base &&
+			len(capabilityVersion) > 0 && (len(version) == 0 || compareVersions(capabilityVersion, version) > 0) {
+			version = capabilityVersion
+		}
+	}
+	return version
+}
+
+// HasCapabilityVersion returns true if the server announced a capability in at least the given version,
+// e.g. HasCapabilityVersion("urn:ietf:params:netconf:base", "1.1")
+func (s *Session) HasCapabilityVersion(base string, minVersion string) bool {
+	version := s.CapabilityVersion(base)
+	return len(version) > 0 && compareVersions(version, minVersion) >= 0
+}
+
+// Module returns the YANG module with the given name or nil if the server did not announce it
+func (s *Session) Module(name string) *Module {
+	return s.Modules[name]
+}
+
+// HasModule returns true if the server announced the YANG module in at least the given revision,
+// an empty minimum revision matches any revision
+func (s *Session) HasModule(name string, minRevision string) bool {
+	module := s.Modules[name]
+	return module != nil && module.HasRevision(minRevision)
+}
+
+// HasFeature returns true if the server announced the YANG module with support for the given feature
+func (s *Session) HasFeature(module string, feature string) bool {
+	m := s.Modules[module]
+	return m != nil && m.HasFeature(feature)
+}
@@ -0,0 +1,75 @@
+/**
+ * Copyright (c) 2019-2020 Cisco Systems
+ *
+ * Author: Steven Barth <stbarth@cisco.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package netconf
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCapabilities(t *testing.T) {
+	session := &Session{Capabilities: map[string]string{
+		CapNetconf10:         "",
+		CapNetconf11:         "",
+		CapConfirmedCommit10: "",
+		"urn:ietf:params:netconf:capability:example:1.10": "",
+		"urn:ietf:params:netconf:capability:example:1.9":  "",
+		"http://cisco.com/ns/yang/Cisco-IOS-XE-native":    "module=Cisco-IOS-XE-native&revision=2019-11-01",
+		NsNetconfMonitoring: "module=ietf-netconf-monitoring&amp;revision=2010-10-04",
+		"urn:ietf:params:xml:ns:yang:ietf-interfaces": "module=ietf-interfaces&revision=2014-05-08" +
+			"&features=pre-provisioning,if-mib,arbitrary-names&deviations=cisco-xe-ietf-interfaces-deviation",
+		"urn:ietf:params:netconf:capability:yang-library:1.0": "revision=2016-06-21&module-set-id=1",
+	}}
+	session.Modules = parseModules(session.Capabilities)
+
+	if version := session.CapabilityVersion("urn:ietf:params:netconf:base"); version != "1.1" {
+		t.Errorf("Unexpected base version %q", version)
+	} else if version := session.CapabilityVersion("urn:ietf:params:netconf:capability:example"); version != "1.10" {
+		t.Errorf("Unexpected example version %q", version)
+	} else if session.HasCapabilityVersion("urn:ietf:params:netconf:capability:confirmed-commit", "1.1") {
+		t.Error("Unexpected confirmed-commit:1.1")
+	} else if !session.HasCapabilityVersion("urn:ietf:params:netconf:capability:confirmed-commit", "1") {
+		t.Error("Missing confirmed-commit:1.0")
+	} else if session.CapabilityVersion("urn:ietf:params:xml:ns:yang") != "" {
+		t.Error("Unexpected version of namespace prefix")
+	}
+
+	if len(session.Modules) != 3 {
+		t.Errorf("Unexpected modules %v", session.Modules)
+	} else if !session.HasModule("Cisco-IOS-XE-native", "") || !session.HasModule("Cisco-IOS-XE-native", "2019-11-01") {
+		t.Error("Missing Cisco-IOS-XE-native")
+	} else if session.HasModule("Cisco-IOS-XE-native", "2020-03-01") || session.HasModule("ietf-yang-library", "") {
+		t.Error("Unexpected module")
+	} else if !session.HasModule("ietf-netconf-monitoring", "2010-10-04") {
+		t.Error("Missing ietf-netconf-monitoring with escaped query")
+	} else if !session.HasFeature("ietf-interfaces", "if-mib") || session.HasFeature("ietf-interfaces", "if") {
+		t.Error("Unexpected features")
+	}
+
+	expected := &Module{
+		Name:       "ietf-interfaces",
+		Namespace:  "urn:ietf:params:xml:ns:yang:ietf-interfaces",
+		Revision:   "2014-05-08",
+		Features:   []string{"pre-provisioning", "if-mib", "arbitrary-names"},
+		Deviations: []string{"cisco-xe-ietf-interfaces-deviation"},
+	}
+	if module := session.Module("ietf-interfaces"); !reflect.DeepEqual(module, expected) {
+		t.Errorf("Unexpected module %+v", module)
+	}
+}
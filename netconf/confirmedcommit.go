@@ -0,0 +1,208 @@
+/**
+ * Copyright (c) 2019-2020 Cisco Systems
+ *
+ * Author: Steven Barth <stbarth@cisco.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package netconf
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrConfirmedCommitUnsupported indicates that the server does not support the requested confirmed commit,
+// e.g. persist tokens without confirmed-commit:1.1 or any confirmed commit without the candidate datastore
+var ErrConfirmedCommitUnsupported = errors.New("Server does not support the requested confirmed commit")
+
+// ErrConfirmedCommitDone indicates that a confirmed commit was already confirmed or cancelled
+var ErrConfirmedCommitDone = errors.New("Confirmed commit already confirmed or cancelled")
+
+// DefaultConfirmTimeout is the confirm timeout used by servers if none is given (RFC 6241)
+const DefaultConfirmTimeout = 600 * time.Second
+
+// ConfirmedCommitOptions defines parameters of a confirmed commit
+type ConfirmedCommitOptions struct {
+	Timeout    time.Duration // DefaultConfirmTimeout if zero
+	PersistID  string        // Random token if empty
+	WarnBefore time.Duration // Call OnWarning this long before the timeout expires
+	OnWarning  func(commit *ConfirmedCommit, remaining time.Duration)
+}
+
+// ConfirmedCommit tracks a pending persistent confirmed commit (RFC 6241).
+// It can be confirmed, cancelled or extended from any session using its persist token.
+type ConfirmedCommit struct {
+	PersistID string
+
+	options   ConfirmedCommitOptions
+	operation sync.Mutex // Serializes operations on the server without blocking the state
+	mutex     sync.Mutex
+	deadline  time.Time
+	warning   *time.Timer
+	done      bool
+}
+
+// StartConfirmedCommit commits the candidate datastore with a confirm timeout and persist token
+func (s *Session) StartConfirmedCommit(ctx context.Context, options ConfirmedCommitOptions) (*ConfirmedCommit, error) {
+	if options.PersistID == "" {
+		token := make([]byte, 16)
+		if _, err := rand.Read(token); err != nil {
+			return nil, err
+		}
+		options.PersistID = hex.EncodeToString(token)
+	}
+
+	c := &ConfirmedCommit{PersistID: options.PersistID, options: options}
+	if err := c.commit(ctx, s, options.Timeout, false); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// ResumeConfirmedCommit tracks a confirmed commit started elsewhere, e.g. by a previous process
+func ResumeConfirmedCommit(persistID string, deadline time.Time, options ConfirmedCommitOptions) *ConfirmedCommit {
+	options.PersistID = persistID
+	c := &ConfirmedCommit{PersistID: persistID, options: options}
+	c.mutex.Lock()
+	c.schedule(deadline)
+	c.mutex.Unlock()
+	return c
+}
+
+// commit sends a confirmed commit, either starting or extending the pending one
+func (c *ConfirmedCommit) commit(ctx context.Context, s *Session, timeout time.Duration, extend bool) error {
+	if !s.HasCapability(CapConfirmedCommit) {
+		return ErrConfirmedCommitUnsupported
+	}
+	if timeout <= 0 {
+		timeout = DefaultConfirmTimeout
+	}
+
+	c.operation.Lock()
+	defer c.operation.Unlock()
+	if c.isDone() {
+		return ErrConfirmedCommitDone
+	}
+
+	seconds := uint((timeout + time.Second - 1) / time.Second)
+	request := &CommitConfirmed{ConfirmTimeout: &seconds, Persist: &c.PersistID}
+	if extend {
+		request.PersistID = &c.PersistID
+	}
+
+	// Measure from before sending so the local deadline never exceeds the server's
+	start := time.Now()
+	if err := s.CallSimpleContext(ctx, request); err != nil {
+		return err
+	}
+	c.mutex.Lock()
+	c.schedule(start.Add(time.Duration(seconds) * time.Second))
+	c.mutex.Unlock()
+	return nil
+}
+
+// isDone returns true if the commit was confirmed or cancelled
+func (c *ConfirmedCommit) isDone() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.done
+}
+
+// schedule sets the deadline and the expiry warning, the mutex must be held
+func (c *ConfirmedCommit) schedule(deadline time.Time) {
+	c.deadline = deadline
+	if c.warning != nil {
+		c.warning.Stop()
+		c.warning = nil
+	}
+
+	if c.options.OnWarning != nil {
+		c.warning = time.AfterFunc(time.Until(deadline.Add(-c.options.WarnBefore)), func() {
+			c.options.OnWarning(c, c.Remaining())
+		})
+	}
+}
+
+// finish marks the commit as confirmed or cancelled, the mutex must be held
+func (c *ConfirmedCommit) finish() {
+	c.done = true
+	if c.warning != nil {
+		c.warning.Stop()
+		c.warning = nil
+	}
+}
+
+// Deadline returns the local estimate of when the server rolls back the commit
+func (c *ConfirmedCommit) Deadline() time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.deadline
+}
+
+// Remaining returns the time left until the commit is rolled back
+func (c *ConfirmedCommit) Remaining() time.Duration {
+	if remaining := time.Until(c.Deadline()); remaining > 0 {
+		return remaining
+	}
+	return 0
+}
+
+// Expired returns true if the confirm timeout has passed according to the local estimate
+func (c *ConfirmedCommit) Expired() bool {
+	return c.Remaining() == 0
+}
+
+// Extend resets the confirm timeout of the pending commit, the session may differ from the original one
+func (c *ConfirmedCommit) Extend(ctx context.Context, s *Session, timeout time.Duration) error {
+	return c.commit(ctx, s, timeout, true)
+}
+
+// Confirm makes the pending commit permanent, the session may differ from the original one
+func (c *ConfirmedCommit) Confirm(ctx context.Context, s *Session) error {
+	c.operation.Lock()
+	defer c.operation.Unlock()
+	if c.isDone() {
+		return ErrConfirmedCommitDone
+	}
+
+	err := s.CallSimpleContext(ctx, &Commit{PersistID: &c.PersistID})
+	if err == nil {
+		c.mutex.Lock()
+		c.finish()
+		c.mutex.Unlock()
+	}
+	return err
+}
+
+// Cancel rolls back the pending commit immediately, the session may differ from the original one
+func (c *ConfirmedCommit) Cancel(ctx context.Context, s *Session) error {
+	c.operation.Lock()
+	defer c.operation.Unlock()
+	if c.isDone() {
+		return ErrConfirmedCommitDone
+	}
+
+	err := s.CallSimpleContext(ctx, &CancelCommit{PersistID: &c.PersistID})
+	if err == nil {
+		c.mutex.Lock()
+		c.finish()
+		c.mutex.Unlock()
+	}
+	return err
+}
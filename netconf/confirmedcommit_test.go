@@ -0,0 +1,149 @@
+/**
+ * Copyright (c) 2019-2020 Cisco Systems
+ *
+ * Author: Steven Barth <stbarth@cisco.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package netconf
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestConfirmedCommit(t *testing.T) {
+	server := newConfigServer(CapCandidate, CapConfirmedCommit)
+	session, other := startPipeServer(t, server.Server), startPipeServer(t, server.Server)
+	ctx := context.Background()
+
+	start := time.Now()
+	commit, err := session.StartConfirmedCommit(ctx, ConfirmedCommitOptions{Timeout: 1500 * time.Millisecond, PersistID: "token"})
+	if err != nil {
+		t.Fatal(err)
+	} else if deadline := commit.Deadline(); deadline.Before(start.Add(2*time.Second)) || deadline.After(time.Now().Add(2*time.Second)) {
+		t.Fatalf("Unexpected deadline %v", deadline)
+	}
+	checkOperations(t, server, "commit confirmed timeout=2 persist=token")
+
+	// Extending and confirming may use other sessions, failures keep the commit pending
+	if err := commit.Extend(ctx, other, time.Minute); err != nil {
+		t.Fatal(err)
+	} else if remaining := commit.Remaining(); remaining <= 59*time.Second || commit.Expired() {
+		t.Fatalf("Unexpected remaining time %v", remaining)
+	}
+	server.fail["commit"] = TagOperationFailed
+	if err := commit.Confirm(ctx, other); !errors.Is(err, TagOperationFailed) {
+		t.Fatalf("Expected operation-failed, got %v", err)
+	}
+	delete(server.fail, "commit")
+	if err := commit.Confirm(ctx, other); err != nil {
+		t.Fatal(err)
+	}
+	checkOperations(t, server, "commit confirmed timeout=60 persist=token persist-id=token",
+		"commit persist-id=token", "commit persist-id=token")
+
+	if err := commit.Confirm(ctx, session); err != ErrConfirmedCommitDone {
+		t.Fatalf("Expected ErrConfirmedCommitDone, got %v", err)
+	} else if err := commit.Extend(ctx, session, time.Minute); err != ErrConfirmedCommitDone {
+		t.Fatalf("Expected ErrConfirmedCommitDone, got %v", err)
+	} else if err := commit.Cancel(ctx, session); err != ErrConfirmedCommitDone {
+		t.Fatalf("Expected ErrConfirmedCommitDone, got %v", err)
+	}
+	checkOperations(t, server)
+
+	// Random persist tokens and the default timeout are used if not given
+	commit, err = session.StartConfirmedCommit(ctx, ConfirmedCommitOptions{})
+	if err != nil {
+		t.Fatal(err)
+	} else if len(commit.PersistID) != 32 {
+		t.Fatalf("Unexpected persist token %q", commit.PersistID)
+	} else if err := commit.Cancel(ctx, other); err != nil {
+		t.Fatal(err)
+	}
+	checkOperations(t, server, "commit confirmed timeout=600 persist="+commit.PersistID,
+		"cancel-commit persist-id="+commit.PersistID)
+}
+
+func TestConfirmedCommitUnsupported(t *testing.T) {
+	server := newConfigServer(CapCandidate, CapConfirmedCommit10)
+	session := startPipeServer(t, server.Server)
+
+	if _, err := session.StartConfirmedCommit(context.Background(), ConfirmedCommitOptions{}); err != ErrConfirmedCommitUnsupported {
+		t.Fatalf("Expected ErrConfirmedCommitUnsupported, got %v", err)
+	}
+	checkOperations(t, server)
+}
+
+func TestConfirmedCommitWarning(t *testing.T) {
+	warned := make(chan time.Duration, 1)
+	commit := ResumeConfirmedCommit("token", time.Now().Add(100*time.Millisecond), ConfirmedCommitOptions{
+		WarnBefore: 80 * time.Millisecond,
+		OnWarning: func(commit *ConfirmedCommit, remaining time.Duration) {
+			warned <- remaining
+		},
+	})
+	if commit.PersistID != "token" || commit.Expired() {
+		t.Fatalf("Unexpected commit %+v", commit)
+	}
+
+	select {
+	case remaining := <-warned:
+		if remaining > 80*time.Millisecond {
+			t.Fatalf("Warned too early with %v remaining", remaining)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("No warning before expiry")
+	}
+}
+
+func TestConfirmedCommitBlocked(t *testing.T) {
+	server := newConfigServer(CapCandidate, CapConfirmedCommit)
+	session := startPipeServer(t, server.Server)
+	commit, err := session.StartConfirmedCommit(context.Background(), ConfirmedCommitOptions{Timeout: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The state stays accessible while the device is slow to respond
+	block := make(chan struct{})
+	server.block["commit"] = block
+	extended := make(chan error, 1)
+	go func() {
+		extended <- commit.Extend(context.Background(), session, time.Hour)
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	remaining := make(chan time.Duration, 1)
+	go func() {
+		remaining <- commit.Remaining()
+	}()
+	select {
+	case r := <-remaining:
+		if r > time.Minute {
+			t.Fatalf("Remaining time %v extended early", r)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Remaining blocked by pending extension")
+	}
+
+	close(block)
+	if err := <-extended; err != nil {
+		t.Fatal(err)
+	} else if r := commit.Remaining(); r <= time.Minute {
+		t.Fatalf("Unexpected remaining time %v", r)
+	}
+}
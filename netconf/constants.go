@@ -20,28 +20,39 @@ package netconf
 
 // List of standardized constants related to the NETCONF protocol and its extensions
 const (
-	NsNetconf             = "urn:ietf:params:xml:ns:netconf:base:1.0"
-	NsNetconfWithDefaults = "urn:ietf:params:xml:ns:yang:ietf-netconf-with-defaults"
-	NsNetconfNotification = "urn:ietf:params:xml:ns:netconf:notification:1.0"
-	NsNetmodNotification  = "urn:ietf:params:xml:ns:netmod:notification"
-	NsNetconfMonitoring   = "urn:ietf:params:xml:ns:yang:ietf-netconf-monitoring"
-	NsTailfActions        = "http://tail-f.com/ns/netconf/actions/1.0"
+	NsNetconf                 = "urn:ietf:params:xml:ns:netconf:base:1.0"
+	NsNetconfWithDefaults     = "urn:ietf:params:xml:ns:yang:ietf-netconf-with-defaults"
+	NsNetconfNotification     = "urn:ietf:params:xml:ns:netconf:notification:1.0"
+	NsNetmodNotification      = "urn:ietf:params:xml:ns:netmod:notification"
+	NsNetconfMonitoring       = "urn:ietf:params:xml:ns:yang:ietf-netconf-monitoring"
+	NsTailfActions            = "http://tail-f.com/ns/netconf/actions/1.0"
+	NsSubscribedNotifications = "urn:ietf:params:xml:ns:yang:ietf-subscribed-notifications"
+	NsYangPush                = "urn:ietf:params:xml:ns:yang:ietf-yang-push"
+	NsYangPatch               = "urn:ietf:params:xml:ns:yang:ietf-yang-patch"
+	NsDatastores              = "urn:ietf:params:xml:ns:yang:ietf-datastores"
+	NsNetconfNMDA             = "urn:ietf:params:xml:ns:yang:ietf-netconf-nmda"
+	NsOrigin                  = "urn:ietf:params:xml:ns:yang:ietf-origin"
+	NsYangLibrary             = "urn:ietf:params:xml:ns:yang:ietf-yang-library"
 
-	CapNetconf10       = "urn:ietf:params:netconf:base:1.0"
-	CapNetconf11       = "urn:ietf:params:netconf:base:1.1"
-	CapConfirmedCommit = "urn:ietf:params:netconf:capability:confirmed-commit:1.1"
-	CapValidate        = "urn:ietf:params:netconf:capability:validate:1.1"
-	CapWithDefaults    = "urn:ietf:params:netconf:capability:with-defaults:1.0"
-	CapNotifiction     = "urn:ietf:params:netconf:capability:notification:1.0"
-	CapInterleave      = "urn:ietf:params:netconf:capability:interleave:1.0"
-	CapStartup         = "urn:ietf:params:netconf:capability:startup:1.0"
-	CapWritableRunning = "urn:ietf:params:netconf:capability:writable-running:1.0"
-	CapCandidate       = "urn:ietf:params:netconf:capability:candidate:1.0"
-	CapRollbackOnError = "urn:ietf:params:netconf:capability:rollback-on-error:1.0"
-	CapURL             = "urn:ietf:params:netconf:capability:url:1.0"
-	CapXPath           = "urn:ietf:params:netconf:capability:xpath:1.0"
-	CapMonitoring      = NsNetconfMonitoring
-	CapTailfActions    = NsTailfActions
+	CapNetconf10         = "urn:ietf:params:netconf:base:1.0"
+	CapNetconf11         = "urn:ietf:params:netconf:base:1.1"
+	CapConfirmedCommit   = "urn:ietf:params:netconf:capability:confirmed-commit:1.1"
+	CapValidate          = "urn:ietf:params:netconf:capability:validate:1.1"
+	CapConfirmedCommit10 = "urn:ietf:params:netconf:capability:confirmed-commit:1.0"
+	CapValidate10        = "urn:ietf:params:netconf:capability:validate:1.0"
+	CapWithDefaults      = "urn:ietf:params:netconf:capability:with-defaults:1.0"
+	CapNotifiction       = "urn:ietf:params:netconf:capability:notification:1.0"
+	CapInterleave        = "urn:ietf:params:netconf:capability:interleave:1.0"
+	CapStartup           = "urn:ietf:params:netconf:capability:startup:1.0"
+	CapWritableRunning   = "urn:ietf:params:netconf:capability:writable-running:1.0"
+	CapCandidate         = "urn:ietf:params:netconf:capability:candidate:1.0"
+	CapRollbackOnError   = "urn:ietf:params:netconf:capability:rollback-on-error:1.0"
+	CapURL               = "urn:ietf:params:netconf:capability:url:1.0"
+	CapXPath             = "urn:ietf:params:netconf:capability:xpath:1.0"
+	CapMonitoring        = NsNetconfMonitoring
+	CapTailfActions      = NsTailfActions
+	CapYangLibrary       = "urn:ietf:params:netconf:capability:yang-library:1.0"
+	CapYangLibrary11     = "urn:ietf:params:netconf:capability:yang-library:1.1"
 
 	Running   Datastore = "running"
 	Candidate Datastore = "candidate"
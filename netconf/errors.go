@@ -0,0 +1,230 @@
+/**
+ * Copyright (c) 2019-2020 Cisco Systems
+ *
+ * Author: Steven Barth <stbarth@cisco.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package netconf
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ErrorTag identifies an rpc-error condition (RFC 6241 Appendix A).
+// Tags can be used as targets for errors.Is to check for rpc-errors with the respective tag.
+type ErrorTag string
+
+// List of error tags defined in RFC 6241
+const (
+	TagInUse                 ErrorTag = "in-use"
+	TagInvalidValue          ErrorTag = "invalid-value"
+	TagTooBig                ErrorTag = "too-big"
+	TagMissingAttribute      ErrorTag = "missing-attribute"
+	TagBadAttribute          ErrorTag = "bad-attribute"
+	TagUnknownAttribute      ErrorTag = "unknown-attribute"
+	TagMissingElement        ErrorTag = "missing-element"
+	TagBadElement            ErrorTag = "bad-element"
+	TagUnknownElement        ErrorTag = "unknown-element"
+	TagUnknownNamespace      ErrorTag = "unknown-namespace"
+	TagAccessDenied          ErrorTag = "access-denied"
+	TagLockDenied            ErrorTag = "lock-denied"
+	TagResourceDenied        ErrorTag = "resource-denied"
+	TagRollbackFailed        ErrorTag = "rollback-failed"
+	TagDataExists            ErrorTag = "data-exists"
+	TagDataMissing           ErrorTag = "data-missing"
+	TagOperationNotSupported ErrorTag = "operation-not-supported"
+	TagOperationFailed       ErrorTag = "operation-failed"
+	TagPartialOperation      ErrorTag = "partial-operation"
+	TagMalformedMessage      ErrorTag = "malformed-message"
+)
+
+func (t ErrorTag) Error() string {
+	return "NETCONF RPC error " + string(t)
+}
+
+// List of error severities
+const (
+	SeverityError   = "error"
+	SeverityWarning = "warning"
+)
+
+// Is reports whether the rpc-error has the given error tag
+func (e RPCError) Is(target error) bool {
+	tag, ok := target.(ErrorTag)
+	return ok && string(tag) == e.ErrorTag
+}
+
+// IsWarning returns true if the rpc-error only has warning severity
+func (e RPCError) IsWarning() bool {
+	return e.ErrorSeverity == SeverityWarning
+}
+
+// Path parses the error-path of the rpc-error
+func (e RPCError) Path() ([]PathSegment, error) {
+	return ParseErrorPath(e.ErrorPath)
+}
+
+// RPCErrors aggregates all <rpc-error> elements of an <rpc-reply>.
+// It supports errors.Is and errors.As for each of the contained errors of severity error.
+type RPCErrors struct {
+	Errors   []RPCError
+	Warnings []RPCError
+}
+
+func (e *RPCErrors) Error() string {
+	if len(e.Errors) == 1 {
+		return e.Errors[0].Error()
+	}
+
+	messages := make([]string, len(e.Errors))
+	for i, rpcError := range e.Errors {
+		messages[i] = rpcError.ErrorTag + ": " + rpcError.ErrorMessage
+	}
+	return fmt.Sprintf("NETCONF RPC errors (%d): %s", len(e.Errors), strings.Join(messages, "; "))
+}
+
+// Unwrap returns the contained errors of severity error
+func (e *RPCErrors) Unwrap() []error {
+	errs := make([]error, len(e.Errors))
+	for i := range e.Errors {
+		errs[i] = &e.Errors[i]
+	}
+	return errs
+}
+
+// Err returns an *RPCErrors if the reply contains rpc-errors of severity error or nil otherwise
+func (r *RPCReply) Err() error {
+	errs := &RPCErrors{}
+	for _, rpcError := range r.RPCError {
+		if rpcError.IsWarning() {
+			errs.Warnings = append(errs.Warnings, rpcError)
+		} else {
+			errs.Errors = append(errs.Errors, rpcError)
+		}
+	}
+
+	if len(errs.Errors) == 0 {
+		return nil
+	}
+	return errs
+}
+
+// Warnings returns all rpc-errors of severity warning
+func (r *RPCReply) Warnings() []RPCError {
+	var warnings []RPCError
+	for _, rpcError := range r.RPCError {
+		if rpcError.IsWarning() {
+			warnings = append(warnings, rpcError)
+		}
+	}
+	return warnings
+}
+
+// PathPredicate is a key or leaf-list value predicate of an error-path segment
+type PathPredicate struct {
+	Prefix string
+	Name   string // "." for leaf-list values
+	Value  string
+}
+
+// PathSegment is a single node of a parsed error-path instance identifier
+type PathSegment struct {
+	Prefix     string
+	Name       string
+	Predicates []PathPredicate
+	Position   int // Positional predicate, 0 if none
+}
+
+// ParseErrorPath parses an error-path instance identifier like /t:top/t:list[t:key='value']/t:leaf
+func ParseErrorPath(path string) ([]PathSegment, error) {
+	path = strings.TrimSpace(path)
+	if len(path) == 0 {
+		return nil, nil
+	} else if path[0] != '/' {
+		return nil, fmt.Errorf("Invalid error-path %q: not absolute", path)
+	}
+
+	var segments []PathSegment
+	for i := 1; i <= len(path); {
+		// Node name up to the next predicate or separator
+		end := i
+		for end < len(path) && path[end] != '/' && path[end] != '[' {
+			end++
+		}
+		if end == i {
+			return nil, fmt.Errorf("Invalid error-path %q: empty node name", path)
+		}
+
+		segment := PathSegment{}
+		segment.Prefix, segment.Name = splitQName(path[i:end])
+
+		// Predicates
+		for i = end; i < len(path) && path[i] == '['; {
+			closing, err := predicateEnd(path, i)
+			if err != nil {
+				return nil, err
+			}
+
+			predicate := strings.TrimSpace(path[i+1 : closing])
+			if position, err := strconv.Atoi(predicate); err == nil {
+				segment.Position = position
+			} else if eq := strings.IndexByte(predicate, '='); eq > 0 {
+				key, value := strings.TrimSpace(predicate[:eq]), strings.TrimSpace(predicate[eq+1:])
+				if len(value) < 2 || (value[0] != '\'' && value[0] != '"') || value[len(value)-1] != value[0] {
+					return nil, fmt.Errorf("Invalid error-path %q: unquoted predicate value", path)
+				}
+				p := PathPredicate{Value: value[1 : len(value)-1]}
+				p.Prefix, p.Name = splitQName(key)
+				segment.Predicates = append(segment.Predicates, p)
+			} else {
+				return nil, fmt.Errorf("Invalid error-path %q: bad predicate", path)
+			}
+			i = closing + 1
+		}
+
+		segments = append(segments, segment)
+		if i < len(path) && path[i] != '/' {
+			return nil, fmt.Errorf("Invalid error-path %q: unexpected character", path)
+		}
+		i++
+	}
+	return segments, nil
+}
+
+// predicateEnd returns the index of the bracket closing the predicate starting at start
+func predicateEnd(path string, start int) (int, error) {
+	var quote byte
+	for i := start + 1; i < len(path); i++ {
+		if quote != 0 {
+			if path[i] == quote {
+				quote = 0
+			}
+		} else if path[i] == '\'' || path[i] == '"' {
+			quote = path[i]
+		} else if path[i] == ']' {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("Invalid error-path %q: unterminated predicate", path)
+}
+
+func splitQName(qname string) (string, string) {
+	if colon := strings.IndexByte(qname, ':'); colon >= 0 {
+		return qname[:colon], qname[colon+1:]
+	}
+	return "", qname
+}
@@ -0,0 +1,122 @@
+/**
+ * Copyright (c) 2019-2020 Cisco Systems
+ *
+ * Author: Steven Barth <stbarth@cisco.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package netconf
+
+import (
+	"encoding/xml"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestParseErrorPath(t *testing.T) {
+	tests := []struct {
+		path     string
+		segments []PathSegment
+	}{
+		{"", nil},
+		{"/t:top", []PathSegment{{Prefix: "t", Name: "top"}}},
+		{" /t:top/leaf \n", []PathSegment{{Prefix: "t", Name: "top"}, {Name: "leaf"}}},
+		{"/t:top/t:list[t:key='a/b]'][name=\"x\"]/t:leaf", []PathSegment{
+			{Prefix: "t", Name: "top"},
+			{Prefix: "t", Name: "list", Predicates: []PathPredicate{{Prefix: "t", Name: "key", Value: "a/b]"}, {Name: "name", Value: "x"}}},
+			{Prefix: "t", Name: "leaf"},
+		}},
+		{"/t:top/t:values[.='1']/t:list[3]", []PathSegment{
+			{Prefix: "t", Name: "top"},
+			{Prefix: "t", Name: "values", Predicates: []PathPredicate{{Name: ".", Value: "1"}}},
+			{Prefix: "t", Name: "list", Position: 3},
+		}},
+	}
+	for _, test := range tests {
+		if segments, err := ParseErrorPath(test.path); err != nil || !reflect.DeepEqual(segments, test.segments) {
+			t.Fatalf("Path %q: unexpected segments %+v: %v", test.path, segments, err)
+		}
+	}
+
+	for _, path := range []string{"t:top", "/t:top//leaf", "/t:list[key=value]", "/t:list[key='value'", "/t:list[]", "/t:list[1]x"} {
+		if _, err := ParseErrorPath(path); err == nil {
+			t.Fatalf("Path %q: expected error", path)
+		}
+	}
+}
+
+func TestRPCErrors(t *testing.T) {
+	reply := &RPCReply{}
+	if err := xml.Unmarshal([]byte(`<rpc-reply xmlns="urn:ietf:params:xml:ns:netconf:base:1.0">
+		<rpc-error><error-tag>in-use</error-tag><error-severity>error</error-severity></rpc-error>
+		<rpc-error><error-tag>data-missing</error-tag><error-severity>warning</error-severity></rpc-error>
+		<rpc-error><error-tag>lock-denied</error-tag><error-severity>error</error-severity>
+			<error-path>/t:top</error-path><error-info><session-id>4</session-id></error-info></rpc-error>
+		</rpc-reply>`), reply); err != nil {
+		t.Fatal(err)
+	}
+
+	err := reply.Err()
+	var rpcErrors *RPCErrors
+	var rpcError *RPCError
+	if !errors.As(err, &rpcErrors) || len(rpcErrors.Errors) != 2 || len(rpcErrors.Warnings) != 1 {
+		t.Fatalf("Unexpected errors %v", err)
+	} else if !errors.Is(err, TagInUse) || !errors.Is(err, TagLockDenied) || errors.Is(err, TagDataMissing) {
+		t.Fatalf("Unexpected error tags of %v", err)
+	} else if !errors.As(err, &rpcError) || rpcError.ErrorTag != "in-use" {
+		t.Fatalf("Unexpected first error %v", rpcError)
+	} else if warnings := reply.Warnings(); len(warnings) != 1 || !warnings[0].IsWarning() {
+		t.Fatalf("Unexpected warnings %v", warnings)
+	} else if path, err := rpcErrors.Errors[1].Path(); err != nil || len(path) != 1 || path[0].Name != "top" {
+		t.Fatalf("Unexpected path %v: %v", path, err)
+	}
+	if sessionID := lockDeniedSessionID(err); sessionID != 4 {
+		t.Fatalf("Unexpected lock holder %d", sessionID)
+	}
+
+	// Only warnings are no error
+	reply.RPCError = reply.RPCError[1:2]
+	if err := reply.Err(); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+}
+
+func TestCallSimpleErrors(t *testing.T) {
+	server := NewServer()
+	server.Handle(xml.Name{Space: NsNetconf, Local: "lock"}, func(s *ServerSession, r *ServerRequest) (interface{}, error) {
+		return nil, TagLockDenied
+	})
+	server.Handle(xml.Name{Space: NsNetconf, Local: "unlock"}, func(s *ServerSession, r *ServerRequest) (interface{}, error) {
+		return nil, &RPCErrors{Errors: []RPCError{
+			{ErrorType: "protocol", ErrorTag: string(TagInUse), ErrorSeverity: SeverityError},
+			{ErrorType: "protocol", ErrorTag: string(TagAccessDenied), ErrorSeverity: SeverityError},
+		}}
+	})
+	session := startPipeServer(t, server)
+
+	// A single rpc-error keeps its type, several are aggregated
+	err := session.CallSimple(&Lock{Target: Running})
+	if rpcError, ok := err.(*RPCError); !ok || rpcError.ErrorTag != string(TagLockDenied) || !errors.Is(err, TagLockDenied) {
+		t.Fatalf("Expected *RPCError, got %#v", err)
+	}
+
+	err = session.CallSimple(&Unlock{Target: Running})
+	var rpcError *RPCError
+	if rpcErrors, ok := err.(*RPCErrors); !ok || len(rpcErrors.Errors) != 2 {
+		t.Fatalf("Expected *RPCErrors, got %#v", err)
+	} else if !errors.Is(err, TagInUse) || !errors.Is(err, TagAccessDenied) || !errors.As(err, &rpcError) {
+		t.Fatalf("Unexpected error tags of %v", err)
+	}
+}
@@ -21,6 +21,7 @@ package netconf
 import (
 	"bytes"
 	"errors"
+	"fmt"
 	"io"
 	"strconv"
 )
@@ -28,14 +29,81 @@ import (
 // ErrFraming describes a NETCONF protocol error due to invalid message framing
 var ErrFraming = errors.New("NETCONF message framing error")
 
+// LimitError describes a received message exceeding a limit of the session, it wraps ErrFraming
+type LimitError struct {
+	Limit string // Name of the exceeded limit, e.g. "message size"
+	Max   uint64
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("%s: %s exceeds limit of %d", ErrFraming.Error(), e.Limit, e.Max)
+}
+
+func (e *LimitError) Unwrap() error {
+	return ErrFraming
+}
+
+// Limits restrict messages received from the server, zero values mean unlimited
+type Limits struct {
+	MaxChunkSize   uint32 // Maximum size of a NETCONF 1.1 chunk
+	MaxMessageSize uint64 // Maximum size of a message without framing
+	MaxDepth       int    // Maximum nesting depth of XML elements
+}
+
+// messageScanner scans a received message once to classify a reply and enforce message size
+// and XML depth limits, the session is closed if they are exceeded
+type messageScanner struct {
+	session *Session
+	reader  io.ReadCloser
+	limits  Limits
+	size    uint64
+	scanner replyScanner
+	err     error
+}
+
+func (r *messageScanner) Read(p []byte) (int, error) {
+	if r.err != nil {
+		return 0, r.err
+	}
+
+	n, err := r.reader.Read(p)
+	r.size += uint64(n)
+	r.scanner.Write(p[:n])
+
+	if max := r.limits.MaxMessageSize; max > 0 && r.size > max {
+		r.err = &LimitError{Limit: "message size", Max: max}
+	} else if max := r.limits.MaxDepth; max > 0 && r.scanner.maxDepth > max {
+		r.err = &LimitError{Limit: "XML depth", Max: uint64(max)}
+	} else if errors.Is(err, ErrFraming) {
+		r.err = err
+	} else {
+		return n, err
+	}
+
+	// The rest of the message cannot be skipped safely, neither after limits nor framing errors
+	r.session.fail(r.err)
+	return 0, r.err
+}
+
+// Close skips the rest of the message subject to the limits
+func (r *messageScanner) Close() error {
+	if _, err := io.Copy(io.Discard, r); err != nil {
+		return err
+	}
+	return r.reader.Close()
+}
+
 // NETCONF 1.0 message delimiter sequence
 var eom = []byte{']', ']', '>', ']', ']', '>'}
 
+// Maximum chunk size of NETCONF 1.1 chunked framing (RFC 6242)
+const maxChunkSize = 4294967295
+
 type framerV10 struct {
 	writer io.Writer
 }
 
-func newFramerV10(writer io.Writer) io.WriteCloser {
+func newFramerV10(writer io.Writer, chunkSize uint32) io.WriteCloser {
 	return &framerV10{writer: writer}
 }
 
@@ -48,164 +116,370 @@ func (c *framerV10) Close() error {
 	return err
 }
 
-type unframerV10 struct {
-	reader io.Reader
+// readBufferSize is the size of the buffer between transport and unframers
+const readBufferSize = 64 * 1024
+
+// Maximum length of a chunk header: "\n#" + 10 digits + "\n"
+const maxChunkHeader = 13
+
+// messageReader buffers a transport for unframers. In contrast to bufio it exposes the buffered
+// data so that unframers can parse framing in place and read large chunk bodies directly.
+type messageReader struct {
+	source io.Reader
 	buffer []byte
-	len    int
+	start  int
+	end    int
 	err    error
 }
 
-func newUnframerV10(reader io.Reader) io.ReadCloser {
-	return &unframerV10{reader: reader, buffer: make([]byte, len(eom))}
+func newMessageReader(source io.Reader) *messageReader {
+	return &messageReader{source: source, buffer: make([]byte, readBufferSize)}
 }
 
-func (c *unframerV10) Read(p []byte) (int, error) {
-	if c.err != nil {
-		return 0, c.err
-	}
+// buffered returns the data read ahead
+func (r *messageReader) buffered() []byte {
+	return r.buffer[r.start:r.end]
+}
 
-	for c.len < len(c.buffer) {
-		n, err := c.reader.Read(c.buffer[c.len:])
-		if err != nil {
-			if err == io.EOF {
-				err = ErrFraming
-			}
-			c.err = err
-			return 0, c.err
+// discard drops n bytes of buffered data
+func (r *messageReader) discard(n int) {
+	r.start += n
+}
+
+// peek waits until at least n bytes are buffered filling as much of the buffer as the transport returns
+func (r *messageReader) peek(n int) ([]byte, error) {
+	for r.end-r.start < n {
+		if r.err != nil {
+			return r.buffered(), r.err
 		}
-		c.len += n
-	}
 
-	var i int
-	for i = 0; i < len(c.buffer); i++ {
-		if bytes.Equal(c.buffer[i:], eom[:len(eom)-i]) {
-			break
+		if r.start > 0 {
+			r.end = copy(r.buffer, r.buffered())
+			r.start = 0
 		}
+
+		var read int
+		read, r.err = r.source.Read(r.buffer[r.end:])
+		r.end += read
 	}
+	return r.buffered(), nil
+}
 
-	if i == 0 {
-		c.err = io.EOF
+// Read returns buffered data if available and reads directly from the transport otherwise
+func (r *messageReader) Read(p []byte) (int, error) {
+	if r.start < r.end {
+		n := copy(p, r.buffered())
+		r.start += n
+		return n, nil
+	} else if r.err != nil {
+		return 0, r.err
+	}
+	return r.source.Read(p)
+}
+
+type unframerV10 struct {
+	reader  *messageReader
+	scanned int // Length of buffered data known not to contain the delimiter
+	err     error
+}
+
+func newUnframerV10(reader *messageReader, maxChunk uint32) io.ReadCloser {
+	return &unframerV10{reader: reader}
+}
+
+func (c *unframerV10) Read(p []byte) (int, error) {
+	if c.err != nil || len(p) == 0 {
 		return 0, c.err
 	}
 
-	len := copy(p, c.buffer[:i])
-	c.len = copy(c.buffer, c.buffer[len:])
+	// Data scanned by a previous call is returned without searching it again
+	if c.scanned > 0 {
+		return c.consume(p, c.reader.buffered()[:c.scanned])
+	}
+
+	// Operate on everything buffered holding back what could be the start of the delimiter
+	buffered, err := c.reader.peek(len(eom))
+	if i := bytes.Index(buffered, eom); i == 0 {
+		c.reader.discard(len(eom))
+		c.err = io.EOF
+	} else if i > 0 {
+		c.scanned = i
+		return c.consume(p, buffered[:i])
+	} else if err == nil {
+		c.scanned = len(buffered) - partialDelimiter(buffered)
+		return c.consume(p, buffered[:c.scanned])
+	} else if err == io.EOF {
+		c.err = ErrFraming
+	} else {
+		c.err = err
+	}
+	return 0, c.err
+}
+
+// consume copies already scanned data from the buffered reader
+func (c *unframerV10) consume(p []byte, data []byte) (int, error) {
+	n := copy(p, data)
+	c.reader.discard(n)
+	c.scanned -= n
+	return n, nil
+}
 
-	return len, nil
+// partialDelimiter returns the length of the longest suffix of data which is a prefix of the delimiter
+func partialDelimiter(data []byte) int {
+	for n := len(eom) - 1; n > 0; n-- {
+		if bytes.HasSuffix(data, eom[:n]) {
+			return n
+		}
+	}
+	return 0
 }
 
 func (c *unframerV10) Close() error {
-	dummy := make([]byte, 16)
-	for {
-		_, err := c.Read(dummy)
-		if err == io.EOF {
-			return nil
-		} else if err != nil {
-			return err
+	for c.err == nil {
+		buffered, err := c.reader.peek(len(eom))
+		if i := bytes.Index(buffered, eom); i >= 0 {
+			c.reader.discard(i + len(eom))
+			c.err = io.EOF
+		} else if err == nil {
+			c.reader.discard(len(buffered) - partialDelimiter(buffered))
+		} else if err == io.EOF {
+			c.err = ErrFraming
+		} else {
+			c.err = err
 		}
 	}
+
+	if c.err == io.EOF {
+		return nil
+	}
+	return c.err
 }
 
+// DefaultChunkSize is the maximum size of chunks sent with NETCONF 1.1 framing unless configured otherwise
+const DefaultChunkSize = 64 * 1024
+
+// NETCONF 1.1 end-of-chunks marker
+var endOfChunks = []byte{'\n', '#', '#', '\n'}
+
+// framerV11 coalesces writes into chunks of up to chunkSize bytes
 type framerV11 struct {
-	writer io.Writer
+	writer    io.Writer
+	chunkSize uint64
+	buffer    []byte // Chunk data preceded by space for the chunk header
 }
 
-func newFramerV11(writer io.Writer) io.WriteCloser {
-	return &framerV11{writer: writer}
+func newFramerV11(writer io.Writer, chunkSize uint32) io.WriteCloser {
+	if chunkSize == 0 {
+		chunkSize = DefaultChunkSize
+	}
+
+	capacity := uint64(DefaultChunkSize)
+	if uint64(chunkSize) < capacity {
+		capacity = uint64(chunkSize)
+	}
+	buffer := make([]byte, maxChunkHeader, maxChunkHeader+capacity+uint64(len(endOfChunks)))
+	return &framerV11{writer: writer, chunkSize: uint64(chunkSize), buffer: buffer}
 }
 
 func (c *framerV11) Write(p []byte) (int, error) {
-	if len(p) > 0 {
-		_, err := c.writer.Write([]byte("\n#" + strconv.Itoa(len(p)) + "\n"))
-		if err != nil {
-			return 0, err
+	written := len(p)
+	for len(p) > 0 {
+		buffered := uint64(len(c.buffer) - maxChunkHeader)
+		if buffered == 0 && uint64(len(p)) >= c.chunkSize {
+			// Send full chunks directly without copying
+			_, err := c.writer.Write([]byte("\n#" + strconv.FormatUint(c.chunkSize, 10) + "\n"))
+			if err == nil {
+				_, err = c.writer.Write(p[:c.chunkSize])
+			}
+			if err != nil {
+				return 0, err
+			}
+			p = p[c.chunkSize:]
+			continue
+		}
+
+		n := len(p)
+		if free := c.chunkSize - buffered; uint64(n) > free {
+			n = int(free)
+		}
+		c.buffer = append(c.buffer, p[:n]...)
+		p = p[n:]
+
+		if uint64(len(c.buffer)-maxChunkHeader) == c.chunkSize {
+			if err := c.flush(false); err != nil {
+				return 0, err
+			}
 		}
 	}
-	return c.writer.Write(p)
+	return written, nil
 }
 
-func (c *framerV11) Close() error {
-	_, err := c.writer.Write([]byte{'\n', '#', '#', '\n'})
+// flush sends the buffered data as one chunk, optionally followed by the end-of-chunks marker
+func (c *framerV11) flush(final bool) error {
+	start := maxChunkHeader
+	if size := len(c.buffer) - maxChunkHeader; size > 0 {
+		header := "\n#" + strconv.Itoa(size) + "\n"
+		start -= len(header)
+		copy(c.buffer[start:], header)
+	}
+	if final {
+		c.buffer = append(c.buffer, endOfChunks...)
+	}
+
+	var err error
+	if start < len(c.buffer) {
+		_, err = c.writer.Write(c.buffer[start:])
+	}
+	c.buffer = c.buffer[:maxChunkHeader]
 	return err
 }
 
+func (c *framerV11) Close() error {
+	return c.flush(true)
+}
+
 type unframerV11 struct {
-	reader io.Reader
-	len    int
-	err    error
+	reader   *messageReader
+	maxChunk uint64
+	len      uint64
+	err      error
 }
 
-func newUnframerV11(reader io.Reader) io.ReadCloser {
-	return &unframerV11{reader: reader}
+func newUnframerV11(reader *messageReader, maxChunk uint32) io.ReadCloser {
+	if maxChunk == 0 {
+		return &unframerV11{reader: reader, maxChunk: maxChunkSize}
+	}
+	return &unframerV11{reader: reader, maxChunk: uint64(maxChunk)}
 }
 
-func (c *unframerV11) Read(p []byte) (int, error) {
-	if c.err != nil || len(p) == 0 {
-		return 0, c.err
-	} else if c.len == 0 {
-		_, err := c.reader.Read(p[:1])
-		if err == nil && p[0] == '\n' {
-			_, err = c.reader.Read(p[:1])
-			if (err == nil && p[0] != '#') || err == io.EOF {
-				err = ErrFraming
-			}
-		} else if err == nil || err == io.EOF {
+// readHeader parses a buffered chunk header and returns the chunk size or io.EOF for an end-of-chunks marker
+func (c *unframerV11) readHeader() (uint64, error) {
+	header, err := c.reader.peek(4)
+	if err != nil {
+		if err == io.EOF {
 			err = ErrFraming
 		}
+		return 0, err
+	} else if header[0] != '\n' || header[1] != '#' {
+		return 0, ErrFraming
+	} else if header[2] == '#' {
+		if header[3] != '\n' {
+			return 0, ErrFraming
+		}
+		c.reader.discard(4)
+		return 0, io.EOF
+	} else if header[2] < '1' || header[2] > '9' {
+		return 0, ErrFraming
+	}
 
-		for err == nil {
-			_, err = c.reader.Read(p[:1])
-			if err == nil {
-				if c.len == 0 && p[0] == '#' {
-					_, err = c.reader.Read(p[:1])
-					if err == nil && p[0] == '\n' {
-						err = io.EOF
-					} else if err == nil || err == io.EOF {
-						err = ErrFraming
-					}
-				} else if p[0] >= '0' && p[0] <= '9' {
-					c.len = c.len*10 + int(p[0]-'0')
-				} else if p[0] == '\n' && c.len > 0 {
-					break
-				} else {
+	// Chunk size is at most 10 digits followed by a newline
+	var size uint64
+	for i := 2; i < maxChunkHeader; i++ {
+		if i >= len(header) {
+			if header, err = c.reader.peek(i + 1); err != nil {
+				if err == io.EOF {
 					err = ErrFraming
 				}
-			} else if err == io.EOF {
-				err = ErrFraming
+				return 0, err
 			}
 		}
 
-		if err != nil {
-			c.err = err
-			return 0, c.err
+		if b := header[i]; b >= '0' && b <= '9' {
+			size = size*10 + uint64(b-'0')
+		} else if b == '\n' && size > maxChunkSize {
+			break
+		} else if b == '\n' && size > c.maxChunk {
+			return 0, &LimitError{Limit: "chunk size", Max: c.maxChunk}
+		} else if b == '\n' {
+			c.reader.discard(i + 1)
+			return size, nil
+		} else {
+			break
 		}
 	}
+	return 0, ErrFraming
+}
 
-	if c.len < len(p) {
-		p = p[:c.len]
+// headerBuffered checks if a complete chunk header is buffered and can be parsed without blocking
+func (c *unframerV11) headerBuffered() bool {
+	buffered := c.reader.buffered()
+	if len(buffered) > maxChunkHeader {
+		buffered = buffered[:maxChunkHeader]
 	}
+	return len(buffered) > 1 && bytes.IndexByte(buffered[1:], '\n') >= 0
+}
 
-	n, err := c.reader.Read(p)
-	if err != nil {
-		if err == io.EOF {
-			err = ErrFraming
-		}
-		c.err = err
+func (c *unframerV11) Read(p []byte) (int, error) {
+	if c.err != nil || len(p) == 0 {
 		return 0, c.err
+	} else if c.len == 0 {
+		if c.len, c.err = c.readHeader(); c.err != nil {
+			return 0, c.err
+		}
 	}
 
-	c.len -= n
-	return n, nil
+	// Fill p from consecutive chunks as long as no read would block on a header
+	var n int
+	for {
+		chunk := p[n:]
+		if c.len < uint64(len(chunk)) {
+			chunk = chunk[:c.len]
+		}
+
+		// Only chunk data larger than the buffer is read directly from the transport,
+		// the rest is read through the buffer together with the following headers.
+		var read int
+		var err error
+		if len(c.reader.buffered()) == 0 && c.len < readBufferSize {
+			_, err = c.reader.peek(1)
+		}
+		if err == nil {
+			read, err = c.reader.Read(chunk)
+		}
+		n += read
+		c.len -= uint64(read)
+		if err != nil {
+			if err == io.EOF {
+				err = ErrFraming
+			}
+			c.err = err
+			if n == 0 {
+				return 0, c.err
+			}
+			return n, nil
+		}
+
+		if n == len(p) || c.len > 0 || !c.headerBuffered() {
+			return n, nil
+		} else if c.len, c.err = c.readHeader(); c.err != nil {
+			return n, nil
+		}
+	}
 }
 
 func (c *unframerV11) Close() error {
-	dummy := make([]byte, 16)
-	for {
-		_, err := c.Read(dummy)
+	for c.err == nil {
+		if c.len == 0 {
+			c.len, c.err = c.readHeader()
+			continue
+		}
+
+		buffered, err := c.reader.peek(1)
+		if uint64(len(buffered)) > c.len {
+			buffered = buffered[:c.len]
+		}
+		c.reader.discard(len(buffered))
+		c.len -= uint64(len(buffered))
+
 		if err == io.EOF {
-			return nil
-		} else if err != nil {
-			return err
+			c.err = ErrFraming
+		} else {
+			c.err = err
 		}
 	}
+
+	if c.err == io.EOF {
+		return nil
+	}
+	return c.err
 }
@@ -0,0 +1,261 @@
+/**
+ * Copyright (c) 2019-2020 Cisco Systems
+ *
+ * Author: Steven Barth <stbarth@cisco.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package netconf
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+	"testing/iotest"
+)
+
+// RFC 6242 Section 4.2 example of a chunked <close-session> request
+const rfc6242ChunkedExample = "\n#4\n<rpc\n#18\n message-id=\"102\"\n\n#79\n" +
+	"     xmlns=\"urn:ietf:params:xml:ns:netconf:base:1.0\">\n  <close-session/>\n</rpc>\n##\n"
+
+const rfc6242ChunkedPayload = "<rpc message-id=\"102\"\n" +
+	"     xmlns=\"urn:ietf:params:xml:ns:netconf:base:1.0\">\n  <close-session/>\n</rpc>"
+
+// RFC 6242 Section 3 end-of-message framed <hello>
+const rfc6242HelloExample = "<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n" +
+	"<hello xmlns=\"urn:ietf:params:xml:ns:netconf:base:1.0\">\n" +
+	"  <capabilities>\n    <capability>urn:ietf:params:netconf:base:1.1</capability>\n  </capabilities>\n" +
+	"</hello>\n]]>]]>"
+
+// framingTest describes a framed input, the payloads of its messages and whether the last one is invalid
+type framingTest struct {
+	name     string
+	v11      bool
+	input    string
+	messages []string
+	invalid  bool
+}
+
+var framingTests = []framingTest{
+	// End-of-message framing
+	{name: "rfc6242 hello", input: rfc6242HelloExample, messages: []string{rfc6242HelloExample[:len(rfc6242HelloExample)-6]}},
+	{name: "empty message", input: "]]>]]>", messages: []string{""}},
+	{name: "two messages", input: "<a/>]]>]]><b/>]]>]]>", messages: []string{"<a/>", "<b/>"}},
+	{name: "partial delimiters", input: "]]]>]]]]>]>]]>]]>", messages: []string{"]]]>]]]]>]>"}},
+	{name: "delimiter prefix at end", input: "<a/>]]>]]", invalid: true},
+	{name: "leading LF", input: "\n<a/>]]>]]>", messages: []string{"\n<a/>"}},
+	{name: "missing delimiter", input: "<a/>", invalid: true},
+	{name: "eof", input: "", invalid: true},
+
+	// Chunked framing
+	{name: "rfc6242 chunked", v11: true, input: rfc6242ChunkedExample, messages: []string{rfc6242ChunkedPayload}},
+	{name: "two chunked messages", v11: true, input: "\n#4\n<a/>\n##\n\n#4\n<b/>\n##\n", messages: []string{"<a/>", "<b/>"}},
+	{name: "end of chunks only", v11: true, input: "\n##\n", messages: []string{""}},
+	{name: "chunk containing LF", v11: true, input: "\n#5\n\n<a/>\n##\n", messages: []string{"\n<a/>"}},
+	{name: "chunk containing header", v11: true, input: "\n#4\n\n##\n\n##\n", messages: []string{"\n##\n"}},
+	{name: "maximum chunk size", v11: true, input: "\n#4294967295\n<a/>", invalid: true},
+	{name: "missing leading LF", v11: true, input: "#4\n<a/>\n##\n", invalid: true},
+	{name: "missing hash", v11: true, input: "\n4\n<a/>\n##\n", invalid: true},
+	{name: "zero-length chunk", v11: true, input: "\n#0\n\n##\n", invalid: true},
+	{name: "leading zero", v11: true, input: "\n#04\n<a/>\n##\n", invalid: true},
+	{name: "chunk size overflow", v11: true, input: "\n#4294967296\n<a/>\n##\n", invalid: true},
+	{name: "chunk size 11 digits", v11: true, input: "\n#10000000000\n<a/>\n##\n", invalid: true},
+	{name: "chunk size 20 digits", v11: true, input: "\n#18446744073709551617\n<a/>\n##\n", invalid: true},
+	{name: "non-digit chunk size", v11: true, input: "\n#4a\n<a/>\n##\n", invalid: true},
+	{name: "empty chunk size", v11: true, input: "\n#\n<a/>\n##\n", invalid: true},
+	{name: "missing LF after size", v11: true, input: "\n#4<a/>\n##\n", invalid: true},
+	{name: "CRLF after size", v11: true, input: "\n#4\r\n<a/>\n##\n", invalid: true},
+	{name: "missing LF after end", v11: true, input: "\n#4\n<a/>\n##", invalid: true},
+	{name: "garbage after end", v11: true, input: "\n#4\n<a/>\n##x", invalid: true},
+	{name: "truncated chunk", v11: true, input: "\n#5\n<a/>", invalid: true},
+	{name: "truncated header", v11: true, input: "\n#4\n<a/>\n#", invalid: true},
+	{name: "missing end of chunks", v11: true, input: "\n#4\n<a/>", invalid: true},
+	{name: "end of message delimiter", v11: true, input: "<a/>]]>]]>", invalid: true},
+}
+
+func newTestUnframer(v11 bool, reader *messageReader) io.ReadCloser {
+	if v11 {
+		return newUnframerV11(reader, 0)
+	}
+	return newUnframerV10(reader, 0)
+}
+
+// checkFraming reads all messages of a framing test either reading or skipping them
+func checkFraming(t *testing.T, test framingTest, source io.Reader, skip bool) {
+	t.Helper()
+	reader := newMessageReader(source)
+	for i, expected := range test.messages {
+		unframer := newTestUnframer(test.v11, reader)
+		if skip {
+			if err := unframer.Close(); err != nil {
+				t.Fatalf("Message %d: skip failed: %v", i, err)
+			}
+			continue
+		}
+
+		data, err := io.ReadAll(unframer)
+		if err != nil {
+			t.Fatalf("Message %d: unexpected error: %v", i, err)
+		} else if string(data) != expected {
+			t.Fatalf("Message %d: got %q, expected %q", i, data, expected)
+		} else if err = unframer.Close(); err != nil {
+			t.Fatalf("Message %d: close failed: %v", i, err)
+		}
+	}
+
+	unframer := newTestUnframer(test.v11, reader)
+	var err error
+	if skip {
+		err = unframer.Close()
+	} else {
+		_, err = io.ReadAll(unframer)
+	}
+
+	if test.invalid && !errors.Is(err, ErrFraming) {
+		t.Fatalf("Expected framing error, got %v", err)
+	} else if !test.invalid && err != ErrFraming {
+		t.Fatalf("Expected framing error at end of input, got %v", err)
+	}
+}
+
+func TestFramingConformance(t *testing.T) {
+	for _, test := range framingTests {
+		t.Run(test.name, func(t *testing.T) {
+			for _, skip := range []bool{false, true} {
+				input := []byte(test.input)
+				checkFraming(t, test, bytes.NewReader(input), skip)
+				checkFraming(t, test, iotest.OneByteReader(bytes.NewReader(input)), skip)
+				checkFraming(t, test, iotest.DataErrReader(bytes.NewReader(input)), skip)
+
+				// Split headers and delimiters across read boundaries at every position
+				for split := 1; split < len(input); split++ {
+					checkFraming(t, test, io.MultiReader(bytes.NewReader(input[:split]), bytes.NewReader(input[split:])), skip)
+				}
+			}
+		})
+	}
+}
+
+// writePieces writes data using writes of varying sizes like xml.Encoder
+func writePieces(writer io.Writer, data []byte) {
+	for size := 1; len(data) > 0; size = size*3%17 + 1 {
+		if size > len(data) {
+			size = len(data)
+		}
+		writer.Write(data[:size])
+		data = data[size:]
+	}
+}
+
+func roundTrip(t *testing.T, v11 bool, payload []byte, chunkSize uint32) {
+	var message bytes.Buffer
+	var framer io.WriteCloser
+	if v11 {
+		framer = newFramerV11(&message, chunkSize)
+	} else {
+		framer = newFramerV10(&message, chunkSize)
+	}
+	writePieces(framer, payload)
+	if err := framer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Two messages in a row to ensure the first one is not overread
+	message.Write(message.Bytes())
+	reader := newMessageReader(iotest.HalfReader(&message))
+	for i := 0; i < 2; i++ {
+		data, err := io.ReadAll(newTestUnframer(v11, reader))
+		if err != nil {
+			t.Fatalf("Message %d: unexpected error %v", i, err)
+		} else if !bytes.Equal(data, payload) {
+			t.Fatalf("Message %d: got %q, expected %q", i, data, payload)
+		}
+	}
+}
+
+func TestFramingRoundTrip(t *testing.T) {
+	payloads := []string{"", "<a/>", "]]>]]", "\n##\n", "\n#4\n", rfc6242ChunkedPayload}
+	for _, payload := range payloads {
+		for _, chunkSize := range []uint32{0, 1, 2, 3, 16, maxChunkSize} {
+			roundTrip(t, true, []byte(payload), chunkSize)
+		}
+		roundTrip(t, false, []byte(payload), 0)
+	}
+}
+
+func FuzzUnframerV10(f *testing.F) {
+	for _, test := range framingTests {
+		if !test.v11 {
+			f.Add([]byte(test.input))
+		}
+	}
+
+	f.Fuzz(func(t *testing.T, input []byte) {
+		data, err := io.ReadAll(newUnframerV10(newMessageReader(bytes.NewReader(input)), 0))
+		errSkip := newUnframerV10(newMessageReader(iotest.OneByteReader(bytes.NewReader(input))), 0).Close()
+		if (err == nil) != (errSkip == nil) {
+			t.Fatalf("Read and skip disagree: %v, %v", err, errSkip)
+		} else if err != nil {
+			if !errors.Is(err, ErrFraming) {
+				t.Fatalf("Unexpected error %v", err)
+			}
+			return
+		}
+
+		if bytes.Contains(data, eom) || !bytes.HasPrefix(input, data) {
+			t.Fatalf("Invalid message %q", data)
+		}
+		roundTrip(t, false, data, 0)
+	})
+}
+
+func FuzzUnframerV11(f *testing.F) {
+	for _, test := range framingTests {
+		if test.v11 {
+			f.Add([]byte(test.input))
+		}
+	}
+
+	f.Fuzz(func(t *testing.T, input []byte) {
+		data, err := io.ReadAll(newUnframerV11(newMessageReader(bytes.NewReader(input)), 0))
+		errSkip := newUnframerV11(newMessageReader(iotest.OneByteReader(bytes.NewReader(input))), 0).Close()
+		if (err == nil) != (errSkip == nil) {
+			t.Fatalf("Read and skip disagree: %v, %v", err, errSkip)
+		} else if err != nil {
+			if !errors.Is(err, ErrFraming) {
+				t.Fatalf("Unexpected error %v", err)
+			}
+			return
+		}
+
+		if len(data) >= len(input) {
+			t.Fatalf("Message %q longer than input", data)
+		}
+		roundTrip(t, true, data, 7)
+	})
+}
+
+func FuzzFramerRoundTrip(f *testing.F) {
+	f.Add([]byte(rfc6242ChunkedPayload), uint32(4))
+	f.Add([]byte("\n##\n]]>]]>"), uint32(1))
+	f.Add([]byte{}, uint32(0))
+
+	f.Fuzz(func(t *testing.T, payload []byte, chunkSize uint32) {
+		roundTrip(t, true, payload, chunkSize)
+		if !bytes.Contains(payload, eom) && partialDelimiter(payload) == 0 {
+			roundTrip(t, false, payload, 0)
+		}
+	})
+}
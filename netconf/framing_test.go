@@ -0,0 +1,407 @@
+/**
+ * Copyright (c) 2019-2020 Cisco Systems
+ *
+ * Author: Steven Barth <stbarth@cisco.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package netconf
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Unbuffered unframers as they were before the buffered redesign, kept for comparison
+
+type legacyUnframerV10 struct {
+	reader io.Reader
+	buffer []byte
+	len    int
+	err    error
+}
+
+func (c *legacyUnframerV10) Read(p []byte) (int, error) {
+	if c.err != nil {
+		return 0, c.err
+	}
+
+	for c.len < len(c.buffer) {
+		n, err := c.reader.Read(c.buffer[c.len:])
+		if err != nil {
+			if err == io.EOF {
+				err = ErrFraming
+			}
+			c.err = err
+			return 0, c.err
+		}
+		c.len += n
+	}
+
+	var i int
+	for i = 0; i < len(c.buffer); i++ {
+		if bytes.Equal(c.buffer[i:], eom[:len(eom)-i]) {
+			break
+		}
+	}
+
+	if i == 0 {
+		c.err = io.EOF
+		return 0, c.err
+	}
+
+	len := copy(p, c.buffer[:i])
+	c.len = copy(c.buffer, c.buffer[len:])
+
+	return len, nil
+}
+
+type legacyUnframerV11 struct {
+	reader io.Reader
+	len    int
+	err    error
+}
+
+func (c *legacyUnframerV11) Read(p []byte) (int, error) {
+	if c.err != nil || len(p) == 0 {
+		return 0, c.err
+	} else if c.len == 0 {
+		_, err := c.reader.Read(p[:1])
+		if err == nil && p[0] == '\n' {
+			_, err = c.reader.Read(p[:1])
+			if (err == nil && p[0] != '#') || err == io.EOF {
+				err = ErrFraming
+			}
+		} else if err == nil || err == io.EOF {
+			err = ErrFraming
+		}
+
+		for err == nil {
+			_, err = c.reader.Read(p[:1])
+			if err == nil {
+				if c.len == 0 && p[0] == '#' {
+					_, err = c.reader.Read(p[:1])
+					if err == nil && p[0] == '\n' {
+						err = io.EOF
+					} else if err == nil || err == io.EOF {
+						err = ErrFraming
+					}
+				} else if p[0] >= '0' && p[0] <= '9' {
+					c.len = c.len*10 + int(p[0]-'0')
+				} else if p[0] == '\n' && c.len > 0 {
+					break
+				} else {
+					err = ErrFraming
+				}
+			} else if err == io.EOF {
+				err = ErrFraming
+			}
+		}
+
+		if err != nil {
+			c.err = err
+			return 0, c.err
+		}
+	}
+
+	if c.len < len(p) {
+		p = p[:c.len]
+	}
+
+	n, err := c.reader.Read(p)
+	if err != nil {
+		if err == io.EOF {
+			err = ErrFraming
+		}
+		c.err = err
+		return 0, c.err
+	}
+
+	c.len -= n
+	return n, nil
+}
+
+// channelReader simulates an SSH channel: packets of at most 32 KiB are delivered by another
+// goroutine and every read synchronizes with it, so each read of the transport has a real cost.
+func channelReader(data []byte) io.Reader {
+	reader, writer := io.Pipe()
+	go func() {
+		for len(data) > 0 {
+			n := 32 * 1024
+			if n > len(data) {
+				n = len(data)
+			}
+			if _, err := writer.Write(data[:n]); err != nil {
+				return
+			}
+			data = data[n:]
+		}
+		writer.Close()
+	}()
+	return reader
+}
+
+const benchmarkMessageSize = 4 * 1024 * 1024
+
+func benchmarkPayload() []byte {
+	payload := bytes.Repeat([]byte("<interface><name>GigabitEthernet0/0/0/0</name></interface>\n"), benchmarkMessageSize/60)
+	return payload
+}
+
+func frameV10(payload []byte) []byte {
+	return append(append([]byte{}, payload...), eom...)
+}
+
+func frameV11(payload []byte, chunkSize int) []byte {
+	var message bytes.Buffer
+	for len(payload) > 0 {
+		n := chunkSize
+		if n > len(payload) {
+			n = len(payload)
+		}
+		message.WriteString("\n#" + strconv.Itoa(n) + "\n")
+		message.Write(payload[:n])
+		payload = payload[n:]
+	}
+	message.WriteString("\n##\n")
+	return message.Bytes()
+}
+
+func TestUnframerV10(t *testing.T) {
+	payload := benchmarkPayload()
+	message := frameV10(payload)
+	reader := newMessageReader(channelReader(append(message, message...)))
+
+	for i := 0; i < 2; i++ {
+		data, err := io.ReadAll(newUnframerV10(reader, 0))
+		if err != nil || !bytes.Equal(data, payload) {
+			t.Fatalf("Message %d: unexpected payload of length %d, error %v", i, len(data), err)
+		}
+	}
+}
+
+func TestUnframerV11(t *testing.T) {
+	payload := benchmarkPayload()
+	message := frameV11(payload, 4096)
+	reader := newMessageReader(channelReader(append(message, message...)))
+
+	for i := 0; i < 2; i++ {
+		data, err := io.ReadAll(newUnframerV11(reader, 0))
+		if err != nil || !bytes.Equal(data, payload) {
+			t.Fatalf("Message %d: unexpected payload of length %d, error %v", i, len(data), err)
+		}
+	}
+}
+
+func TestFramerV11(t *testing.T) {
+	payload := benchmarkPayload()[:64*1600]
+	for _, chunkSize := range []uint32{0, 1, 4096, 100000, maxChunkSize} {
+		var message bytes.Buffer
+		framer := newFramerV11(&message, chunkSize)
+		for i := 0; i < len(payload); i += 64 {
+			framer.Write(payload[i : i+64])
+		}
+		framer.Close()
+
+		expectedSize := len(payload)
+		if chunkSize == 0 {
+			expectedSize = DefaultChunkSize
+		} else if uint64(chunkSize) < uint64(expectedSize) {
+			expectedSize = int(chunkSize)
+		}
+		expected := frameV11(payload, expectedSize)
+		if !bytes.Equal(message.Bytes(), expected) {
+			t.Fatalf("Chunk size %d: unexpected framing", chunkSize)
+		}
+
+		data, err := io.ReadAll(newUnframerV11(newMessageReader(&message), 0))
+		if err != nil || !bytes.Equal(data, payload) {
+			t.Fatalf("Chunk size %d: unexpected payload of length %d, error %v", chunkSize, len(data), err)
+		}
+	}
+}
+
+// nopTransport is a transport for sessions which are only used for reading from a buffer
+type nopTransport struct {
+	io.Reader
+	closed bool
+}
+
+func (t *nopTransport) Write(p []byte) (int, error) {
+	return len(p), nil
+}
+
+func (t *nopTransport) Close() error {
+	t.closed = true
+	return nil
+}
+
+func TestLimits(t *testing.T) {
+	payload := []byte("<a><b><c><d>text</d></c></b></a>")
+	for _, test := range []struct {
+		limits Limits
+		limit  string
+	}{
+		{Limits{}, ""},
+		{Limits{MaxChunkSize: 8}, "chunk size"},
+		{Limits{MaxChunkSize: 16, MaxMessageSize: uint64(len(payload))}, ""},
+		{Limits{MaxMessageSize: uint64(len(payload)) - 1}, "message size"},
+		{Limits{MaxDepth: 4}, ""},
+		{Limits{MaxDepth: 3}, "XML depth"},
+	} {
+		transport := &nopTransport{Reader: bytes.NewReader(frameV11(payload, 16))}
+		session := &Session{transport: transport, newUnframer: newUnframerV11, options: SessionOptions{Limits: test.limits}}
+		session.reader = newMessageReader(transport)
+
+		reader := session.NewReader()
+		data, err := io.ReadAll(reader)
+		if test.limit == "" {
+			if err != nil || !bytes.Equal(data, payload) || reader.Close() != nil {
+				t.Fatalf("Limits %+v: unexpected error %v", test.limits, err)
+			}
+			continue
+		}
+
+		var limitErr *LimitError
+		if !errors.As(err, &limitErr) || limitErr.Limit != test.limit || !errors.Is(err, ErrFraming) {
+			t.Fatalf("Limits %+v: unexpected error %v", test.limits, err)
+		} else if !transport.closed || session.aborted() != err || reader.Close() != err {
+			t.Fatalf("Limits %+v: session not closed", test.limits)
+		}
+	}
+
+	// Malformed framing fails the session as well
+	for _, data := range []string{"\n#99999999999\n", "\n#x\n<a/>", "\n#16\n<a/>", "\n#4\n<a/>\n#"} {
+		transport := &nopTransport{Reader: bytes.NewReader([]byte(data))}
+		session := &Session{transport: transport, newUnframer: newUnframerV11}
+		session.reader = newMessageReader(transport)
+
+		reader := session.NewReader()
+		if _, err := io.ReadAll(reader); !errors.Is(err, ErrFraming) {
+			t.Fatalf("Framing %q: unexpected error %v", data, err)
+		} else if !transport.closed || session.aborted() != err || reader.Close() != err {
+			t.Fatalf("Framing %q: session not closed", data)
+		}
+	}
+}
+
+func TestSessionOptions(t *testing.T) {
+	server := NewServer()
+	server.Handle(xml.Name{Space: NsNetconf, Local: "get-config"}, func(s *ServerSession, r *ServerRequest) (interface{}, error) {
+		return &DataContent{InnerXML: []byte("<top xmlns=\"urn:example\">value</top>")}, nil
+	})
+
+	// Limits already apply to the hello message of the server
+	client, transport := net.Pipe()
+	go server.ServeTransport(transport)
+	var limitErr *LimitError
+	if _, err := NewSessionTransport(context.Background(), client, SessionOptions{Limits: Limits{MaxMessageSize: 64}}); !errors.As(err, &limitErr) {
+		t.Fatalf("Expected limit error, got %v", err)
+	}
+
+	sshClient, err := DialSSHWithPassword(startTestServer(t, server), "user", "", ssh.InsecureIgnoreHostKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sshClient.Close()
+
+	// Options pass through a recording client and vice versa
+	var record bytes.Buffer
+	clients := []func(Client) (Client, error){
+		func(client Client) (Client, error) { return RecordClient(client, &record) },
+		func(client Client) (Client, error) { return ClientWithOptions(client, SessionOptions{ChunkSize: 16}) },
+	}
+	for i := 0; i < 2; i++ {
+		record.Reset()
+		var client Client = sshClient
+		for j := range clients {
+			if client, err = clients[(i+j)%2](client); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		session, err := client.NewSession()
+		if err != nil {
+			t.Fatal(err)
+		} else if err = session.Call(&GetConfig{Source: Running}, &RPCReplyData{}); err != nil {
+			t.Fatal(err)
+		} else if err = session.Close(); err != nil {
+			t.Fatal(err)
+		} else if !bytes.Contains(record.Bytes(), []byte(`\n#16\n`)) {
+			t.Fatalf("Order %d: chunk size not applied", i)
+		}
+	}
+
+	if _, err := ClientWithOptions(&testPipeClient{server}, SessionOptions{}); err != ErrSessionOptionsUnsupported {
+		t.Fatalf("Expected unsupported options, got %v", err)
+	}
+}
+
+func benchmarkUnframer(b *testing.B, message []byte, newUnframer func(io.Reader) io.Reader) {
+	buffer := make([]byte, 4096)
+	b.SetBytes(int64(len(message)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		reader := newUnframer(channelReader(message))
+		for {
+			if _, err := reader.Read(buffer); err == io.EOF {
+				break
+			} else if err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+func BenchmarkUnframerV10(b *testing.B) {
+	benchmarkUnframer(b, frameV10(benchmarkPayload()), func(r io.Reader) io.Reader {
+		return newUnframerV10(newMessageReader(r), 0)
+	})
+}
+
+func BenchmarkUnframerV10Legacy(b *testing.B) {
+	benchmarkUnframer(b, frameV10(benchmarkPayload()), func(r io.Reader) io.Reader {
+		return &legacyUnframerV10{reader: r, buffer: make([]byte, len(eom))}
+	})
+}
+
+func BenchmarkUnframerV11(b *testing.B) {
+	for _, chunkSize := range []int{4096, 65536} {
+		message := frameV11(benchmarkPayload(), chunkSize)
+		b.Run(strconv.Itoa(chunkSize), func(b *testing.B) {
+			benchmarkUnframer(b, message, func(r io.Reader) io.Reader {
+				return newUnframerV11(newMessageReader(r), 0)
+			})
+		})
+	}
+}
+
+func BenchmarkUnframerV11Legacy(b *testing.B) {
+	for _, chunkSize := range []int{4096, 65536} {
+		message := frameV11(benchmarkPayload(), chunkSize)
+		b.Run(strconv.Itoa(chunkSize), func(b *testing.B) {
+			benchmarkUnframer(b, message, func(r io.Reader) io.Reader {
+				return &legacyUnframerV11{reader: r}
+			})
+		})
+	}
+}
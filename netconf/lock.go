@@ -0,0 +1,208 @@
+/**
+ * Copyright (c) 2019-2020 Cisco Systems
+ *
+ * Author: Steven Barth <stbarth@cisco.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package netconf
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrLockReleased indicates that a lock was already released
+var ErrLockReleased = errors.New("Lock already released")
+
+// LockPolicy defines how AcquireLock retries and deals with the holder of a lock
+type LockPolicy struct {
+	InitialBackoff time.Duration // Delay before the first retry, 500ms if zero
+	MaxBackoff     time.Duration // Maximum delay between retries, 10s if zero
+	LookupHolder   bool          // Look up the holder in ietf-netconf-monitoring
+	// KillStale decides whether the holder is killed using <kill-session>, each holder is killed once at most
+	KillStale func(holder *LockHolder) bool
+	// OnDenied is called whenever the lock is denied, e.g. for logging
+	OnDenied func(holder *LockHolder)
+}
+
+// LockHolder describes the session holding a lock as far as it is known
+type LockHolder struct {
+	SessionID   uint64        // 0 if unknown or held by a non-NETCONF entity
+	Session     *SessionState // From ietf-netconf-monitoring if looked up and listed
+	LockedTime  time.Time     // From ietf-netconf-monitoring, zero if unknown
+	FirstDenied time.Time     // Time the lock was first denied due to this holder
+	Err         error         // The lock-denied error
+}
+
+func (h *LockHolder) String() string {
+	if h.SessionID == 0 {
+		return "unknown holder"
+	} else if h.Session == nil {
+		return fmt.Sprintf("session %d", h.SessionID)
+	}
+	return fmt.Sprintf("session %d (%s@%s via %s since %s)", h.SessionID, h.Session.Username,
+		h.Session.SourceHost, h.Session.Transport, h.LockedTime.Format(time.RFC3339))
+}
+
+// LockError indicates that a lock was not acquired before the context ended
+type LockError struct {
+	Datastore  Datastore
+	Holder     *LockHolder // Last holder of the lock
+	ContextErr error
+}
+
+func (e *LockError) Error() string {
+	return fmt.Sprintf("Lock of %s datastore not acquired, held by %s: %v", e.Datastore, e.Holder, e.ContextErr)
+}
+
+// Unwrap returns the context error and the lock-denied error
+func (e *LockError) Unwrap() []error {
+	return []error{e.ContextErr, e.Holder.Err}
+}
+
+// LockHandle is a lock acquired with AcquireLock
+type LockHandle struct {
+	Datastore Datastore
+
+	session  *Session
+	mutex    sync.Mutex
+	released bool
+}
+
+// Release the lock by sending <unlock>
+func (l *LockHandle) Release(ctx context.Context) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.released {
+		return ErrLockReleased
+	}
+
+	err := l.session.CallSimpleContext(ctx, &Unlock{Target: l.Datastore})
+	if err == nil {
+		l.released = true
+	}
+	return err
+}
+
+// AcquireLock locks a datastore retrying with exponential backoff while it is held by another session.
+// Errors other than lock-denied are returned immediately, a *LockError is returned if the context ends.
+// Like with CallContext, the session is aborted if the context ends while waiting for a reply.
+func (s *Session) AcquireLock(ctx context.Context, datastore Datastore, policy *LockPolicy) (*LockHandle, error) {
+	p := LockPolicy{InitialBackoff: 500 * time.Millisecond, MaxBackoff: 10 * time.Second}
+	if policy != nil {
+		p.LookupHolder, p.KillStale, p.OnDenied = policy.LookupHolder, policy.KillStale, policy.OnDenied
+		if policy.InitialBackoff > 0 {
+			p.InitialBackoff = policy.InitialBackoff
+		}
+		if policy.MaxBackoff > 0 {
+			p.MaxBackoff = policy.MaxBackoff
+		}
+	}
+
+	var holder *LockHolder
+	killed := make(map[uint64]bool)
+	for backoff := p.InitialBackoff; ; {
+		err := s.CallSimpleContext(ctx, &Lock{Target: datastore})
+		if err == nil {
+			return &LockHandle{Datastore: datastore, session: s}, nil
+		} else if ctx.Err() != nil && holder != nil {
+			return nil, &LockError{Datastore: datastore, Holder: holder, ContextErr: ctx.Err()}
+		} else if !errors.Is(err, TagLockDenied) || ctx.Err() != nil {
+			return nil, err
+		}
+
+		previous := holder
+		holder = &LockHolder{SessionID: lockDeniedSessionID(err), FirstDenied: time.Now(), Err: err}
+		if p.LookupHolder {
+			s.lookupLockHolder(ctx, datastore, holder)
+		}
+		if holder.SessionID != 0 && holder.SessionID == s.SessionID {
+			return nil, err // Already locked by ourselves
+		} else if previous != nil && previous.SessionID == holder.SessionID {
+			holder.FirstDenied = previous.FirstDenied
+		}
+
+		if p.OnDenied != nil {
+			p.OnDenied(holder)
+		}
+
+		// Kill a stale holder and retry right away if successful
+		if p.KillStale != nil && holder.SessionID != 0 && !killed[holder.SessionID] && p.KillStale(holder) {
+			killed[holder.SessionID] = true
+			if s.CallSimpleContext(ctx, &KillSession{SessionID: holder.SessionID}) == nil {
+				continue
+			} else if ctx.Err() != nil {
+				return nil, &LockError{Datastore: datastore, Holder: holder, ContextErr: ctx.Err()}
+			}
+		}
+
+		delay := backoff + time.Duration(rand.Int63n(int64(backoff)/4+1))
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, &LockError{Datastore: datastore, Holder: holder, ContextErr: ctx.Err()}
+		case <-timer.C:
+		}
+
+		if backoff *= 2; backoff > p.MaxBackoff {
+			backoff = p.MaxBackoff
+		}
+	}
+}
+
+// lockDeniedSessionID returns the session-id of the error-info of a lock-denied error or 0
+func lockDeniedSessionID(err error) uint64 {
+	var rpcErrors *RPCErrors
+	var rpcError *RPCError
+	var errs []RPCError
+	if errors.As(err, &rpcErrors) {
+		errs = rpcErrors.Errors
+	} else if errors.As(err, &rpcError) {
+		errs = []RPCError{*rpcError}
+	}
+
+	for _, rpcError := range errs {
+		if rpcError.Is(TagLockDenied) {
+			sessionID, _ := strconv.ParseUint(strings.TrimSpace(rpcError.ErrorInfo.SessionID), 10, 64)
+			return sessionID
+		}
+	}
+	return 0
+}
+
+// lookupLockHolder completes information about the holder from ietf-netconf-monitoring if available
+func (s *Session) lookupLockHolder(ctx context.Context, datastore Datastore, holder *LockHolder) {
+	state, err := s.getNetconfState(ctx, "<datastores/><sessions/>")
+	if err != nil {
+		return
+	}
+
+	if ds := state.Datastore(datastore); ds != nil && ds.GlobalLock != nil {
+		if holder.SessionID == 0 {
+			holder.SessionID = ds.GlobalLock.LockedBySession
+		}
+		if holder.SessionID == ds.GlobalLock.LockedBySession {
+			holder.LockedTime = ds.GlobalLock.LockedTime
+		}
+	}
+	holder.Session = state.Session(holder.SessionID)
+}
@@ -0,0 +1,128 @@
+/**
+ * Copyright (c) 2019-2020 Cisco Systems
+ *
+ * Author: Steven Barth <stbarth@cisco.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package netconf
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newLockServer creates a server implementing a global lock of the running datastore
+func newLockServer() *Server {
+	var mutex sync.Mutex
+	var holder uint64
+	server := NewServer()
+
+	server.Handle(xml.Name{Space: NsNetconf, Local: "lock"}, func(s *ServerSession, r *ServerRequest) (interface{}, error) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if holder != 0 && server.Session(holder) != nil {
+			err := RPCError{ErrorType: "protocol", ErrorTag: string(TagLockDenied), ErrorSeverity: SeverityError}
+			err.ErrorInfo.SessionID = strconv.FormatUint(holder, 10)
+			return nil, err
+		}
+		holder = s.ID
+		return nil, nil
+	})
+	server.Handle(xml.Name{Space: NsNetconf, Local: "unlock"}, func(s *ServerSession, r *ServerRequest) (interface{}, error) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if holder != s.ID {
+			return nil, TagOperationFailed
+		}
+		holder = 0
+		return nil, nil
+	})
+	server.Handle(xml.Name{Space: NsNetconf, Local: "get"}, func(s *ServerSession, r *ServerRequest) (interface{}, error) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		id := strconv.FormatUint(holder, 10)
+		return &DataContent{InnerXML: []byte(`<netconf-state xmlns="` + NsNetconfMonitoring + `">` +
+			`<datastores><datastore><name>running</name><locks><global-lock><locked-by-session>` + id +
+			`</locked-by-session><locked-time>2020-03-01T10:00:00Z</locked-time></global-lock></locks></datastore></datastores>` +
+			`<sessions><session><session-id>` + id + `</session-id><transport>netconf-ssh</transport>` +
+			`<username>admin</username><source-host>192.0.2.1</source-host></session></sessions></netconf-state>`)}, nil
+	})
+	return server
+}
+
+func TestAcquireLock(t *testing.T) {
+	client := &testPipeClient{server: newLockServer()}
+	holder := startPipeServer(t, client.server)
+	other, err := client.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+
+	lock, err := holder.AcquireLock(context.Background(), Running, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Lock is held by the other session until the context ends
+	denied := 0
+	policy := &LockPolicy{InitialBackoff: time.Millisecond, MaxBackoff: 5 * time.Millisecond, OnDenied: func(*LockHolder) {
+		denied++
+	}}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	var lockErr *LockError
+	if _, err = other.AcquireLock(ctx, Running, policy); !errors.As(err, &lockErr) {
+		t.Fatalf("Expected lock error, got %v", err)
+	} else if lockErr.Holder.SessionID != holder.SessionID || denied < 2 {
+		t.Fatalf("Unexpected holder %v after %d denials", lockErr.Holder, denied)
+	} else if !errors.Is(err, TagLockDenied) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Unexpected error %v", err)
+	}
+
+	if err = lock.Release(context.Background()); err != nil {
+		t.Fatal(err)
+	} else if err = lock.Release(context.Background()); err != ErrLockReleased {
+		t.Fatalf("Expected ErrLockReleased, got %v", err)
+	}
+
+	// Kill a stale holder found through netconf-monitoring, the other session may have been aborted
+	if _, err = holder.AcquireLock(context.Background(), Running, nil); err != nil {
+		t.Fatal(err)
+	} else if other, err = client.NewSession(); err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	var stale *LockHolder
+	policy = &LockPolicy{LookupHolder: true, KillStale: func(h *LockHolder) bool {
+		stale = h
+		return true
+	}}
+	if lock, err = other.AcquireLock(context.Background(), Running, policy); err != nil {
+		t.Fatal(err)
+	} else if stale == nil || stale.SessionID != holder.SessionID || stale.Session == nil || stale.Session.Username != "admin" ||
+		!stale.LockedTime.Equal(time.Date(2020, 3, 1, 10, 0, 0, 0, time.UTC)) {
+		t.Fatalf("Unexpected stale holder %+v", stale)
+	} else if err = holder.CallSimple(&Lock{Target: Running}); err == nil {
+		t.Fatal("Killed session still usable")
+	} else if err = lock.Release(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
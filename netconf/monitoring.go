@@ -0,0 +1,153 @@
+/**
+ * Copyright (c) 2019-2020 Cisco Systems
+ *
+ * Author: Steven Barth <stbarth@cisco.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package netconf
+
+import (
+	"context"
+	"encoding/xml"
+	"time"
+)
+
+// Transports of NETCONF sessions defined in ietf-netconf-monitoring
+const (
+	TransportSSH  SessionTransport = "netconf-ssh"
+	TransportTLS  SessionTransport = "netconf-tls"
+	TransportSOAP SessionTransport = "netconf-soap-over-https"
+	TransportBEEP SessionTransport = "netconf-beep"
+)
+
+// SessionTransport is the transport of a session, the name of its identity without prefix
+type SessionTransport string
+
+// UnmarshalXML session transport from a prefixed identityref
+func (t *SessionTransport) UnmarshalXML(decoder *xml.Decoder, start xml.StartElement) error {
+	_, name, err := unmarshalIdentity(decoder, start, NsNetconfMonitoring)
+	*t = SessionTransport(name)
+	return err
+}
+
+// NetconfState models the netconf-state container of ietf-netconf-monitoring (RFC 6022)
+type NetconfState struct {
+	Capabilities []string           `xml:"urn:ietf:params:xml:ns:yang:ietf-netconf-monitoring capabilities>capability"`
+	Datastores   []DatastoreState   `xml:"urn:ietf:params:xml:ns:yang:ietf-netconf-monitoring datastores>datastore"`
+	Schemas      []Schema           `xml:"urn:ietf:params:xml:ns:yang:ietf-netconf-monitoring schemas>schema"`
+	Sessions     []SessionState     `xml:"urn:ietf:params:xml:ns:yang:ietf-netconf-monitoring sessions>session"`
+	Statistics   *NetconfStatistics `xml:"urn:ietf:params:xml:ns:yang:ietf-netconf-monitoring statistics"`
+}
+
+// DatastoreState is a datastore and its locks
+type DatastoreState struct {
+	Name         string        `xml:"urn:ietf:params:xml:ns:yang:ietf-netconf-monitoring name"`
+	GlobalLock   *GlobalLock   `xml:"urn:ietf:params:xml:ns:yang:ietf-netconf-monitoring locks>global-lock"`
+	PartialLocks []PartialLock `xml:"urn:ietf:params:xml:ns:yang:ietf-netconf-monitoring locks>partial-lock"`
+}
+
+// GlobalLock is a lock of an entire datastore using <lock>
+type GlobalLock struct {
+	LockedBySession uint64    `xml:"urn:ietf:params:xml:ns:yang:ietf-netconf-monitoring locked-by-session"`
+	LockedTime      time.Time `xml:"urn:ietf:params:xml:ns:yang:ietf-netconf-monitoring locked-time"`
+}
+
+// PartialLock is a lock of parts of a datastore using <partial-lock> (RFC 5717)
+type PartialLock struct {
+	LockID          uint32    `xml:"urn:ietf:params:xml:ns:yang:ietf-netconf-monitoring lock-id"`
+	LockedBySession uint64    `xml:"urn:ietf:params:xml:ns:yang:ietf-netconf-monitoring locked-by-session"`
+	LockedTime      time.Time `xml:"urn:ietf:params:xml:ns:yang:ietf-netconf-monitoring locked-time"`
+	Select          []string  `xml:"urn:ietf:params:xml:ns:yang:ietf-netconf-monitoring select"`
+	LockedNodes     []string  `xml:"urn:ietf:params:xml:ns:yang:ietf-netconf-monitoring locked-node"`
+}
+
+// SessionState is a session currently established with the server
+type SessionState struct {
+	SessionID        uint64           `xml:"urn:ietf:params:xml:ns:yang:ietf-netconf-monitoring session-id"`
+	Transport        SessionTransport `xml:"urn:ietf:params:xml:ns:yang:ietf-netconf-monitoring transport"`
+	Username         string           `xml:"urn:ietf:params:xml:ns:yang:ietf-netconf-monitoring username"`
+	SourceHost       string           `xml:"urn:ietf:params:xml:ns:yang:ietf-netconf-monitoring source-host"`
+	LoginTime        time.Time        `xml:"urn:ietf:params:xml:ns:yang:ietf-netconf-monitoring login-time"`
+	InRPCs           uint32           `xml:"urn:ietf:params:xml:ns:yang:ietf-netconf-monitoring in-rpcs"`
+	InBadRPCs        uint32           `xml:"urn:ietf:params:xml:ns:yang:ietf-netconf-monitoring in-bad-rpcs"`
+	OutRPCErrors     uint32           `xml:"urn:ietf:params:xml:ns:yang:ietf-netconf-monitoring out-rpc-errors"`
+	OutNotifications uint32           `xml:"urn:ietf:params:xml:ns:yang:ietf-netconf-monitoring out-notifications"`
+}
+
+// NetconfStatistics are server-wide counters
+type NetconfStatistics struct {
+	NetconfStartTime time.Time `xml:"urn:ietf:params:xml:ns:yang:ietf-netconf-monitoring netconf-start-time"`
+	InBadHellos      uint32    `xml:"urn:ietf:params:xml:ns:yang:ietf-netconf-monitoring in-bad-hellos"`
+	InSessions       uint32    `xml:"urn:ietf:params:xml:ns:yang:ietf-netconf-monitoring in-sessions"`
+	DroppedSessions  uint32    `xml:"urn:ietf:params:xml:ns:yang:ietf-netconf-monitoring dropped-sessions"`
+	InRPCs           uint32    `xml:"urn:ietf:params:xml:ns:yang:ietf-netconf-monitoring in-rpcs"`
+	InBadRPCs        uint32    `xml:"urn:ietf:params:xml:ns:yang:ietf-netconf-monitoring in-bad-rpcs"`
+	OutRPCErrors     uint32    `xml:"urn:ietf:params:xml:ns:yang:ietf-netconf-monitoring out-rpc-errors"`
+	OutNotifications uint32    `xml:"urn:ietf:params:xml:ns:yang:ietf-netconf-monitoring out-notifications"`
+}
+
+// Datastore returns the state of a datastore or nil if it is not listed
+func (state *NetconfState) Datastore(datastore Datastore) *DatastoreState {
+	for i := range state.Datastores {
+		if state.Datastores[i].Name == string(datastore) {
+			return &state.Datastores[i]
+		}
+	}
+	return nil
+}
+
+// Session returns the state of a session or nil if it is not listed
+func (state *NetconfState) Session(sessionID uint64) *SessionState {
+	for i := range state.Sessions {
+		if state.Sessions[i].SessionID == sessionID {
+			return &state.Sessions[i]
+		}
+	}
+	return nil
+}
+
+// LockHolder returns the ID of the session holding the global lock of a datastore, 0 if it is not locked
+func (state *NetconfState) LockHolder(datastore Datastore) uint64 {
+	if ds := state.Datastore(datastore); ds != nil && ds.GlobalLock != nil {
+		return ds.GlobalLock.LockedBySession
+	}
+	return 0
+}
+
+// GetNetconfState retrieves the complete netconf-state of the server
+func (s *Session) GetNetconfState(ctx context.Context) (*NetconfState, error) {
+	return s.getNetconfState(ctx, "")
+}
+
+// getNetconfState retrieves netconf-state using <get>, the subtree selects its content
+func (s *Session) getNetconfState(ctx context.Context, subtree string) (*NetconfState, error) {
+	filter := &Filter{Type: "subtree", Subtree: `<netconf-state xmlns="` + NsNetconfMonitoring + `">` + subtree + `</netconf-state>`}
+	reply := &struct {
+		RPCReply
+		Data struct {
+			NetconfState *NetconfState `xml:"urn:ietf:params:xml:ns:yang:ietf-netconf-monitoring netconf-state"`
+		} `xml:"data"`
+	}{}
+	err := s.CallContext(ctx, &Get{Filter: filter}, reply)
+	if err == nil {
+		err = reply.Err()
+	}
+	if err != nil {
+		return nil, err
+	} else if reply.Data.NetconfState == nil {
+		return &NetconfState{}, nil
+	}
+	return reply.Data.NetconfState, nil
+}
@@ -0,0 +1,83 @@
+/**
+ * Copyright (c) 2019-2020 Cisco Systems
+ *
+ * Author: Steven Barth <stbarth@cisco.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package netconf
+
+import (
+	"context"
+	"encoding/xml"
+	"testing"
+	"time"
+)
+
+const testNetconfState = `<netconf-state xmlns="urn:ietf:params:xml:ns:yang:ietf-netconf-monitoring"
+    xmlns:ncm="urn:ietf:params:xml:ns:yang:ietf-netconf-monitoring">
+  <capabilities><capability>urn:ietf:params:netconf:base:1.1</capability></capabilities>
+  <datastores>
+    <datastore><name>running</name>
+      <locks><global-lock><locked-by-session>42</locked-by-session><locked-time>2020-03-01T10:00:00Z</locked-time></global-lock></locks>
+    </datastore>
+    <datastore><name>candidate</name>
+      <locks><partial-lock><lock-id>1</lock-id><locked-by-session>7</locked-by-session>
+        <locked-time>2020-03-01T11:00:00+01:00</locked-time><select>/interfaces</select>
+        <locked-node>/if:interfaces</locked-node></partial-lock></locks>
+    </datastore>
+    <datastore><name>startup</name></datastore>
+  </datastores>
+  <sessions>
+    <session><session-id>42</session-id><transport>ncm:netconf-ssh</transport><username>admin</username>
+      <source-host>192.0.2.1</source-host><login-time>2020-03-01T09:59:00Z</login-time>
+      <in-rpcs>3</in-rpcs><in-bad-rpcs>1</in-bad-rpcs><out-rpc-errors>1</out-rpc-errors><out-notifications>0</out-notifications>
+    </session>
+  </sessions>
+  <statistics><netconf-start-time>2020-03-01T00:00:00Z</netconf-start-time><in-bad-hellos>2</in-bad-hellos>
+    <in-sessions>10</in-sessions><dropped-sessions>1</dropped-sessions><in-rpcs>30</in-rpcs></statistics>
+</netconf-state>`
+
+func TestNetconfState(t *testing.T) {
+	server := NewServer(CapMonitoring + "?module=ietf-netconf-monitoring&revision=2010-10-04")
+	server.Handle(xml.Name{Space: NsNetconf, Local: "get"}, func(s *ServerSession, r *ServerRequest) (interface{}, error) {
+		return &DataContent{InnerXML: []byte(testNetconfState)}, nil
+	})
+
+	state, err := startPipeServer(t, server).GetNetconfState(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	} else if len(state.Capabilities) != 1 || len(state.Datastores) != 3 || state.Statistics.InSessions != 10 {
+		t.Fatalf("Unexpected state %+v", state)
+	}
+
+	holder := state.LockHolder(Running)
+	session := state.Session(holder)
+	if holder != 42 || session == nil {
+		t.Fatalf("Unexpected lock holder %d", holder)
+	} else if session.Transport != TransportSSH || session.Username != "admin" || session.SourceHost != "192.0.2.1" ||
+		session.InRPCs != 3 || !session.LoginTime.Equal(time.Date(2020, 3, 1, 9, 59, 0, 0, time.UTC)) {
+		t.Fatalf("Unexpected session %+v", session)
+	} else if !state.Datastore(Running).GlobalLock.LockedTime.Equal(time.Date(2020, 3, 1, 10, 0, 0, 0, time.UTC)) {
+		t.Fatal("Unexpected lock time")
+	}
+
+	partial := state.Datastore(Candidate).PartialLocks
+	if state.LockHolder(Candidate) != 0 || state.LockHolder(Startup) != 0 || len(partial) != 1 {
+		t.Fatal("Unexpected locks")
+	} else if partial[0].LockedBySession != 7 || partial[0].Select[0] != "/interfaces" ||
+		!partial[0].LockedTime.Equal(time.Date(2020, 3, 1, 10, 0, 0, 0, time.UTC)) {
+		t.Fatalf("Unexpected partial lock %+v", partial[0])
+	}
+}
@@ -0,0 +1,273 @@
+/**
+ * Copyright (c) 2019-2020 Cisco Systems
+ *
+ * Author: Steven Barth <stbarth@cisco.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package netconf
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"sync"
+)
+
+// multiplexer reads all messages of a session in the background and dispatches replies by message-id
+type multiplexer struct {
+	session       *Session
+	mutex         sync.Mutex
+	pending       map[string]chan<- muxMessage // nil channel: request was cancelled, discard reply
+	queue         []muxMessage                 // messages other than correlated replies
+	notifications int                          // number of notifications in the queue
+	dispatch      [][]byte                     // notifications to be passed to handlers
+	dispatched    chan struct{}
+	signal        chan struct{}
+	done          chan struct{}
+	err           error
+}
+
+type muxMessage struct {
+	data         []byte
+	err          error
+	notification bool
+	scanner      *replyScanner // Classification of a reply while it was read
+}
+
+// Multiplex switches the session into multiplexed mode.
+// A background goroutine then reads all incoming messages and correlates rpc-replies by message-id,
+// so that Call and its variants may be used from several goroutines with multiple RPCs in flight.
+// Notifications are passed to handlers registered with OnNotification if there are any and otherwise
+// retained for Receive like in sequential mode. Handlers are called from a separate goroutine so that
+// they do not delay replies, at most 1024 notifications wait for them and older ones are dropped.
+// Other messages which are not correlated replies are queued for Receive,
+// uncorrelated rpc-replies are reported there as *MessageIDError.
+// Multiplex must be called before the session is used concurrently.
+func (s *Session) Multiplex() {
+	if s.mux != nil {
+		return
+	}
+
+	s.mux = &multiplexer{
+		session:    s,
+		pending:    make(map[string]chan<- muxMessage),
+		dispatched: make(chan struct{}, 1),
+		signal:     make(chan struct{}, 1),
+		done:       make(chan struct{}),
+	}
+	go s.mux.run()
+	go s.mux.runDispatch()
+}
+
+func (m *multiplexer) run() {
+	for {
+		data, root, scanner, err := m.session.readMessage()
+		if err != nil {
+			m.shutdown(err)
+			return
+		}
+
+		if root.Name.Local == "rpc-reply" {
+			messageID := attrValue(root, "message-id")
+			m.mutex.Lock()
+			reply, ok := m.pending[messageID]
+			delete(m.pending, messageID)
+			m.mutex.Unlock()
+
+			if ok {
+				if reply != nil {
+					reply <- muxMessage{data: data, scanner: scanner}
+				}
+				continue
+			}
+			err = &MessageIDError{Received: messageID}
+		} else if root.Name.Local == "notification" && m.session.hasNotificationHandlers() {
+			m.mutex.Lock()
+			if len(m.dispatch) >= maxNotificationBacklog {
+				m.dispatch = append(m.dispatch[:0], m.dispatch[1:]...)
+			}
+			m.dispatch = append(m.dispatch, data)
+			m.mutex.Unlock()
+
+			select {
+			case m.dispatched <- struct{}{}:
+			default:
+			}
+			continue
+		}
+
+		m.mutex.Lock()
+		m.enqueue(muxMessage{data: data, err: err, notification: root.Name.Local == "notification"})
+		m.mutex.Unlock()
+		m.notify()
+	}
+}
+
+// runDispatch passes notifications to handlers, they are queued for Receive if the handlers were removed meanwhile
+func (m *multiplexer) runDispatch() {
+	for {
+		m.mutex.Lock()
+		dispatch := m.dispatch
+		m.dispatch = nil
+		m.mutex.Unlock()
+
+		for _, data := range dispatch {
+			if !m.session.dispatchNotification(data) {
+				m.mutex.Lock()
+				m.enqueue(muxMessage{data: data, notification: true})
+				m.mutex.Unlock()
+				m.notify()
+			}
+		}
+
+		select {
+		case <-m.dispatched:
+		case <-m.done:
+			return
+		}
+	}
+}
+
+// enqueue queues a message for Receive dropping the oldest notification if too many are retained
+func (m *multiplexer) enqueue(message muxMessage) {
+	if message.notification && m.notifications >= maxNotificationBacklog {
+		for i := range m.queue {
+			if m.queue[i].notification {
+				m.queue = append(m.queue[:i], m.queue[i+1:]...)
+				m.notifications--
+				break
+			}
+		}
+	}
+	if message.notification {
+		m.notifications++
+	}
+	m.queue = append(m.queue, message)
+}
+
+func (m *multiplexer) notify() {
+	select {
+	case m.signal <- struct{}{}:
+	default:
+	}
+}
+
+func (m *multiplexer) shutdown(err error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.err = err
+	for messageID, reply := range m.pending {
+		if reply != nil {
+			reply <- muxMessage{err: err}
+		}
+		delete(m.pending, messageID)
+	}
+	close(m.done)
+}
+
+func (m *multiplexer) call(ctx context.Context, request interface{}, response interface{}) error {
+	reply := make(chan muxMessage, 1)
+	var messageID string
+
+	// Writes are not interruptible, a cancelled partial write aborts the session
+	err := m.session.withContext(ctx, func() error {
+		var err error
+		messageID, err = m.session.writeRPC(request, func(messageID string) {
+			m.mutex.Lock()
+			defer m.mutex.Unlock()
+			if m.err != nil {
+				reply <- muxMessage{err: m.err}
+			} else if response == nil {
+				m.pending[messageID] = nil // Not waiting for the reply like in sequential mode
+			} else {
+				m.pending[messageID] = reply
+			}
+		})
+		return err
+	})
+	if err != nil {
+		m.mutex.Lock()
+		delete(m.pending, messageID)
+		m.mutex.Unlock()
+		return err
+	} else if response == nil {
+		select {
+		case message := <-reply:
+			return message.err
+		default:
+			return nil
+		}
+	}
+
+	select {
+	case message := <-reply:
+		if message.err != nil {
+			return message.err
+		}
+
+		decoder := xml.NewDecoder(bytes.NewReader(message.data))
+		for {
+			token, err := decoder.Token()
+			if err != nil {
+				return err
+			} else if root, ok := token.(xml.StartElement); ok {
+				return m.session.decodeReply(decoder, root, messageID, response, message.scanner)
+			}
+		}
+	case <-ctx.Done():
+		m.mutex.Lock()
+		if _, ok := m.pending[messageID]; ok {
+			m.pending[messageID] = nil
+		}
+		m.mutex.Unlock()
+		return ctx.Err()
+	}
+}
+
+func (m *multiplexer) receive(ctx context.Context, response interface{}) error {
+	for {
+		m.mutex.Lock()
+		if len(m.queue) > 0 {
+			message := m.queue[0]
+			m.queue = m.queue[1:]
+			if message.notification {
+				m.notifications--
+			}
+			if len(m.queue) > 0 {
+				m.notify() // Wake up further receivers
+			}
+			m.mutex.Unlock()
+
+			if message.err != nil {
+				return message.err
+			}
+			return xml.Unmarshal(message.data, response)
+		}
+		err := m.err
+		m.mutex.Unlock()
+
+		if err != nil {
+			return err
+		}
+
+		select {
+		case <-m.signal:
+		case <-m.done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,125 @@
+/**
+ * Copyright (c) 2019-2020 Cisco Systems
+ *
+ * Author: Steven Barth <stbarth@cisco.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package netconf
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+)
+
+var (
+	testMessageID = regexp.MustCompile(`message-id="([^"]*)"`)
+	testSource    = regexp.MustCompile(`<source><([a-z]+)/?>`)
+)
+
+// testReply creates an rpc-reply with data
+func testReply(messageID string, data string) string {
+	return `<rpc-reply xmlns="urn:ietf:params:xml:ns:netconf:base:1.0" message-id="` + messageID + `"><data>` + data + `</data></rpc-reply>`
+}
+
+func TestMultiplex(t *testing.T) {
+	session, peer := newTestPeer(t)
+	session.Multiplex()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// A blocking notification handler does not delay replies
+	release := make(chan struct{})
+	handled := make(chan int, 2)
+	remove := session.OnNotification(func(notification *RawNotification) {
+		event := &testEvent{}
+		notification.Decode(event)
+		handled <- event.ID
+		<-release
+	})
+	defer remove()
+
+	// Replies sent in reverse order are correlated with their requests
+	sources := []Datastore{Running, Candidate, Startup}
+	var wait sync.WaitGroup
+	errs := make(chan error, len(sources))
+	for _, source := range sources {
+		wait.Add(1)
+		go func(source Datastore) {
+			defer wait.Done()
+			reply := &RPCReplyData{}
+			if err := session.CallContext(ctx, &GetConfig{Source: source}, reply); err != nil {
+				errs <- err
+			} else if data := string(reply.Data.InnerXML); data != string(source) {
+				errs <- errors.New("Reply for " + data + " received for " + string(source))
+			}
+		}(source)
+	}
+
+	var replies []string
+	for range sources {
+		request, err := peer.read()
+		if err != nil {
+			t.Fatal(err)
+		}
+		replies = append(replies, testReply(testMessageID.FindStringSubmatch(request)[1], testSource.FindStringSubmatch(request)[1]))
+	}
+	if err := peer.write(testNotification(1), testNotification(2)); err != nil {
+		t.Fatal(err)
+	}
+	for i := len(replies) - 1; i >= 0; i-- {
+		if err := peer.write(replies[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	wait.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+
+	close(release)
+	if first, second := <-handled, <-handled; first != 1 || second != 2 {
+		t.Fatalf("Unexpected notifications %d and %d", first, second)
+	}
+
+	// Without a response the reply is not awaited and discarded, uncorrelated replies are received
+	go func() {
+		request, _ := peer.read()
+		peer.write(testReply(testMessageID.FindStringSubmatch(request)[1], ""), testReply("99", ""))
+	}()
+
+	var messageIDErr *MessageIDError
+	if err := session.CallContext(ctx, &Lock{Target: Running}, nil); err != nil {
+		t.Fatal(err)
+	} else if err := session.ReceiveContext(ctx, &RPCReply{}); !errors.As(err, &messageIDErr) || messageIDErr.Received != "99" {
+		t.Fatalf("Expected message-id error, got %v", err)
+	}
+
+	// Pending and further calls fail once the session ends
+	go func() {
+		peer.read()
+		peer.conn.Close()
+	}()
+	if err := session.CallSimpleContext(ctx, &Unlock{Target: Running}); err == nil {
+		t.Fatal("Call succeeded on closed session")
+	} else if err := session.CallSimpleContext(ctx, &Unlock{Target: Running}); err == nil {
+		t.Fatal("Call succeeded on closed session")
+	}
+}
@@ -0,0 +1,313 @@
+/**
+ * Copyright (c) 2019-2020 Cisco Systems
+ *
+ * Author: Steven Barth <stbarth@cisco.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package netconftest provides a scripted NETCONF device for unit tests of code using netconf.Session
+package netconftest
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/cisco-ie/netgonf/netconf"
+)
+
+// Matcher decides whether a received request matches an expectation
+type Matcher func(request *netconf.ServerRequest) bool
+
+// Operation matches requests by the qualified name of their operation
+func Operation(namespace string, name string) Matcher {
+	return func(request *netconf.ServerRequest) bool {
+		return request.Operation == xml.Name{Space: namespace, Local: name}
+	}
+}
+
+// Expectation is a scripted request and the reply to it, by default <ok/>
+type Expectation struct {
+	description string
+	matcher     Matcher
+	handler     netconf.ServerHandler
+	times       int
+}
+
+// Reply with the given content, e.g. a struct with an XMLName or &netconf.DataContent{...}
+func (e *Expectation) Reply(content interface{}) *Expectation {
+	return e.Do(func(*netconf.ServerSession, *netconf.ServerRequest) (interface{}, error) {
+		return content, nil
+	})
+}
+
+// ReplyXML replies with raw XML as the content of the <rpc-reply>
+func (e *Expectation) ReplyXML(content string) *Expectation {
+	return e.Reply(rawContent(content))
+}
+
+// ReplyData replies with raw XML as the content of a <data> element
+func (e *Expectation) ReplyData(data string) *Expectation {
+	return e.Reply(&netconf.DataContent{InnerXML: []byte(data)})
+}
+
+// ReplyError replies with rpc-errors, e.g. netconf.TagLockDenied or a netconf.RPCError
+func (e *Expectation) ReplyError(err error) *Expectation {
+	return e.Do(func(*netconf.ServerSession, *netconf.ServerRequest) (interface{}, error) {
+		return nil, err
+	})
+}
+
+// Do replies using a custom handler, e.g. to send notifications
+func (e *Expectation) Do(handler netconf.ServerHandler) *Expectation {
+	e.handler = handler
+	return e
+}
+
+// Times sets how often the expectation must be met in a row, 1 by default
+func (e *Expectation) Times(times int) *Expectation {
+	e.times = times
+	return e
+}
+
+// Device is a scripted NETCONF device. Requests must arrive in the order they were expected.
+// Unexpected requests fail the test and are answered with an operation-failed rpc-error.
+type Device struct {
+	t            testing.TB
+	server       *netconf.Server
+	mutex        sync.Mutex
+	expectations []*Expectation
+}
+
+// NewDevice creates a device announcing the given capabilities in addition to base:1.0 and base:1.1.
+// The test fails if any expectation is unmet once it completes.
+func NewDevice(t testing.TB, capabilities ...string) *Device {
+	device := &Device{t: t, server: netconf.NewServer(capabilities...)}
+	device.server.Handle(xml.Name{}, device.handle)
+	t.Cleanup(func() {
+		device.server.Close()
+		device.mutex.Lock()
+		defer device.mutex.Unlock()
+		for _, expectation := range device.expectations {
+			t.Errorf("netconftest: expected request not received: %s", expectation.description)
+		}
+	})
+	return device
+}
+
+// Server returns the underlying server, e.g. to set limits or register fixed handlers
+func (d *Device) Server() *netconf.Server {
+	return d.server
+}
+
+// Expect a request equal to the given operation struct, e.g. &netconf.Lock{Target: netconf.Running}
+func (d *Device) Expect(request interface{}) *Expectation {
+	data, err := xml.Marshal(request)
+	if err != nil {
+		d.t.Fatalf("netconftest: cannot marshal expected request: %v", err)
+	}
+	return d.ExpectXML(string(data))
+}
+
+// ExpectXML expects a request whose operation element is equal to the given XML after canonicalization.
+// Elements without namespace are in the NETCONF base namespace like they are within <rpc>.
+func (d *Device) ExpectXML(request string) *Expectation {
+	expected, err := canonicalize([]byte(request))
+	if err != nil {
+		d.t.Fatalf("netconftest: invalid expected request: %v", err)
+	}
+
+	return d.ExpectMatch(expected, func(r *netconf.ServerRequest) bool {
+		received := &canonicalElement{}
+		return r.Decode(received) == nil && received.String() == expected
+	})
+}
+
+// ExpectMatch expects a request accepted by the matcher, the description is used in failure messages
+func (d *Device) ExpectMatch(description string, matcher Matcher) *Expectation {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	expectation := &Expectation{description: description, matcher: matcher, times: 1}
+	d.expectations = append(d.expectations, expectation)
+	return expectation
+}
+
+// handle dispatches a request to the next expectation
+func (d *Device) handle(session *netconf.ServerSession, request *netconf.ServerRequest) (interface{}, error) {
+	d.mutex.Lock()
+	var expectation *Expectation
+	if len(d.expectations) > 0 && d.expectations[0].matcher(request) {
+		expectation = d.expectations[0]
+		if expectation.times--; expectation.times <= 0 {
+			d.expectations = d.expectations[1:]
+		}
+	}
+	d.mutex.Unlock()
+
+	if expectation == nil {
+		received := &canonicalElement{}
+		request.Decode(received)
+		d.t.Errorf("netconftest: unexpected request %s", received.String())
+		return nil, netconf.RPCError{ErrorTag: string(netconf.TagOperationFailed), ErrorMessage: "Unexpected request"}
+	} else if expectation.handler == nil {
+		return nil, nil
+	}
+	return expectation.handler(session, request)
+}
+
+// Client returns a client creating sessions to the device over in-memory connections
+func (d *Device) Client() netconf.Client {
+	return &pipeClient{device: d}
+}
+
+// Session creates a session to the device which is closed once the test completes
+func (d *Device) Session() *netconf.Session {
+	session, err := d.Client().NewSession()
+	if err != nil {
+		d.t.Fatalf("netconftest: cannot create session: %v", err)
+	}
+	d.t.Cleanup(func() {
+		session.Close()
+	})
+	return session
+}
+
+type pipeClient struct {
+	device *Device
+}
+
+func (c *pipeClient) NewSession() (*netconf.Session, error) {
+	return c.NewSessionContext(context.Background())
+}
+
+func (c *pipeClient) NewSessionContext(ctx context.Context) (*netconf.Session, error) {
+	client, server := net.Pipe()
+	go c.device.server.ServeTransport(server)
+
+	session, err := netconf.NewSessionTransport(ctx, client, netconf.SessionOptions{})
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+	return session, nil
+}
+
+func (c *pipeClient) Close() error {
+	return nil
+}
+
+// rawContent is reply content given as raw XML
+type rawContent string
+
+// MarshalXML re-encodes the raw XML with the namespaces of its elements
+func (c rawContent) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	decoder := xml.NewDecoder(strings.NewReader(string(c)))
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		switch element := token.(type) {
+		case xml.StartElement:
+			element.Attr = withoutNamespaceDeclarations(element.Attr)
+			err = e.EncodeToken(element)
+		case xml.EndElement, xml.CharData:
+			err = e.EncodeToken(element)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func withoutNamespaceDeclarations(attrs []xml.Attr) []xml.Attr {
+	var filtered []xml.Attr
+	for _, attr := range attrs {
+		if attr.Name.Space != "xmlns" && !(attr.Name.Space == "" && attr.Name.Local == "xmlns") {
+			filtered = append(filtered, attr)
+		}
+	}
+	return filtered
+}
+
+// canonicalElement is an element in a canonical form suitable for comparison: names are qualified
+// by their namespace, attributes are sorted and comments and whitespace between elements are dropped
+type canonicalElement struct {
+	bytes.Buffer
+}
+
+func (c *canonicalElement) UnmarshalXML(decoder *xml.Decoder, start xml.StartElement) error {
+	c.writeStart(start)
+	for depth := 1; depth > 0; {
+		token, err := decoder.Token()
+		if err != nil {
+			return err
+		}
+
+		switch element := token.(type) {
+		case xml.StartElement:
+			c.writeStart(element)
+			depth++
+		case xml.EndElement:
+			fmt.Fprintf(c, "</{%s}%s>", element.Name.Space, element.Name.Local)
+			depth--
+		case xml.CharData:
+			if text := bytes.TrimSpace(element); len(text) > 0 {
+				xml.EscapeText(c, text)
+			}
+		}
+	}
+	return nil
+}
+
+func (c *canonicalElement) writeStart(element xml.StartElement) {
+	attrs := withoutNamespaceDeclarations(element.Attr)
+	sort.Slice(attrs, func(i, j int) bool {
+		if attrs[i].Name.Space != attrs[j].Name.Space {
+			return attrs[i].Name.Space < attrs[j].Name.Space
+		}
+		return attrs[i].Name.Local < attrs[j].Name.Local
+	})
+
+	fmt.Fprintf(c, "<{%s}%s", element.Name.Space, element.Name.Local)
+	for _, attr := range attrs {
+		fmt.Fprintf(c, " {%s}%s=%q", attr.Name.Space, attr.Name.Local, attr.Value)
+	}
+	c.WriteByte('>')
+}
+
+// canonicalize returns the canonical form of an operation element within the NETCONF base namespace
+func canonicalize(operation []byte) (string, error) {
+	rpc := append([]byte(`<rpc xmlns="`+netconf.NsNetconf+`">`), operation...)
+	rpc = append(rpc, "</rpc>"...)
+	request := &struct {
+		Operation canonicalElement `xml:",any"`
+	}{}
+	if err := xml.Unmarshal(rpc, request); err != nil {
+		return "", err
+	} else if request.Operation.Len() == 0 {
+		return "", fmt.Errorf("No operation element in %q", operation)
+	}
+	return request.Operation.String(), nil
+}
@@ -0,0 +1,87 @@
+/**
+ * Copyright (c) 2019-2020 Cisco Systems
+ *
+ * Author: Steven Barth <stbarth@cisco.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package netconftest
+
+import (
+	"encoding/xml"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/cisco-ie/netgonf/netconf"
+)
+
+func TestDevice(t *testing.T) {
+	device := NewDevice(t, netconf.CapCandidate)
+	device.Expect(&netconf.Lock{Target: netconf.Candidate})
+	device.ExpectXML(`<nc:get-config xmlns:nc="urn:ietf:params:xml:ns:netconf:base:1.0">
+		<!-- Whitespace, comments and prefixes are irrelevant -->
+		<nc:source><nc:running/></nc:source>
+	</nc:get-config>`).ReplyData(`<top xmlns="urn:example"><leaf>value</leaf></top>`)
+	device.ExpectMatch("commit", Operation(netconf.NsNetconf, "commit")).ReplyError(netconf.TagInUse).Times(2)
+	device.ExpectXML(`<ping xmlns="urn:example"/>`).ReplyXML(`<pong xmlns="urn:example">1</pong>`)
+	device.ExpectMatch("any", func(*netconf.ServerRequest) bool { return true }).
+		Do(func(session *netconf.ServerSession, request *netconf.ServerRequest) (interface{}, error) {
+			return nil, session.Notify(time.Now(), &struct {
+				XMLName xml.Name `xml:"urn:example event"`
+			}{})
+		})
+
+	session := device.Session()
+	if !session.HasCapability(netconf.CapCandidate) {
+		t.Fatal("Capability missing")
+	}
+
+	if err := session.CallSimple(&netconf.Lock{Target: netconf.Candidate}); err != nil {
+		t.Fatal(err)
+	}
+
+	reply := &netconf.RPCReplyData{}
+	if err := session.Call(&netconf.GetConfig{Source: netconf.Running}, reply); err != nil {
+		t.Fatal(err)
+	} else if string(reply.Data.InnerXML) != `<top xmlns="urn:example"><leaf>value</leaf></top>` {
+		t.Fatalf("Unexpected data %q", reply.Data.InnerXML)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := session.CallSimple(&netconf.Commit{}); !errors.Is(err, netconf.TagInUse) {
+			t.Fatalf("Expected in-use, got %v", err)
+		}
+	}
+
+	pong := &struct {
+		netconf.RPCReply
+		Pong string `xml:"urn:example pong"`
+	}{}
+	if err := session.Call(&struct {
+		XMLName xml.Name `xml:"urn:example ping"`
+	}{}, pong); err != nil || pong.Pong != "1" {
+		t.Fatalf("Unexpected reply %q: %v", pong.Pong, err)
+	}
+
+	if err := session.CallSimple(&netconf.DiscardChanges{}); err != nil {
+		t.Fatal(err)
+	}
+	notification := &struct {
+		Event *struct{} `xml:"urn:example event"`
+	}{}
+	if err := session.Receive(notification); err != nil || notification.Event == nil {
+		t.Fatalf("Notification not received: %v", err)
+	}
+}
@@ -0,0 +1,160 @@
+/**
+ * Copyright (c) 2019-2020 Cisco Systems
+ *
+ * Author: Steven Barth <stbarth@cisco.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package netconf
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"strings"
+)
+
+// OriginIdentity identifies the origin of operational data (RFC 8342) by its YANG identity
+type OriginIdentity struct {
+	Namespace string
+	Name      string
+}
+
+// List of origin identities defined in ietf-origin
+var (
+	OriginIntended = OriginIdentity{Namespace: NsOrigin, Name: "intended"}
+	OriginDynamic  = OriginIdentity{Namespace: NsOrigin, Name: "dynamic"}
+	OriginSystem   = OriginIdentity{Namespace: NsOrigin, Name: "system"}
+	OriginLearned  = OriginIdentity{Namespace: NsOrigin, Name: "learned"}
+	OriginDefault  = OriginIdentity{Namespace: NsOrigin, Name: "default"}
+	OriginUnknown  = OriginIdentity{Namespace: NsOrigin, Name: "unknown"}
+)
+
+// MarshalXML origin identity as prefixed identityref
+func (o OriginIdentity) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	return marshalIdentity(e, start, "or", o.Namespace, o.Name)
+}
+
+// UnmarshalXML origin identity from a prefixed identityref.
+// Prefixes not declared on the element itself are assumed to refer to ietf-origin.
+func (o *OriginIdentity) UnmarshalXML(decoder *xml.Decoder, start xml.StartElement) error {
+	var err error
+	o.Namespace, o.Name, err = unmarshalIdentity(decoder, start, NsOrigin)
+	return err
+}
+
+// GetData defines the <get-data> operation (RFC 8526) for use with Session.Call
+type GetData struct {
+	XMLName             xml.Name          `xml:"urn:ietf:params:xml:ns:yang:ietf-netconf-nmda get-data"`
+	Datastore           DatastoreIdentity `xml:"urn:ietf:params:xml:ns:yang:ietf-netconf-nmda datastore"`
+	SubtreeFilter       *SubtreeFilter    `xml:"urn:ietf:params:xml:ns:yang:ietf-netconf-nmda subtree-filter,omitempty"`
+	XPathFilter         *string           `xml:"urn:ietf:params:xml:ns:yang:ietf-netconf-nmda xpath-filter,omitempty"`
+	ConfigFilter        *bool             `xml:"urn:ietf:params:xml:ns:yang:ietf-netconf-nmda config-filter,omitempty"`
+	OriginFilter        []OriginIdentity  `xml:"urn:ietf:params:xml:ns:yang:ietf-netconf-nmda origin-filter,omitempty"`
+	NegatedOriginFilter []OriginIdentity  `xml:"urn:ietf:params:xml:ns:yang:ietf-netconf-nmda negated-origin-filter,omitempty"`
+	MaxDepth            *uint16           `xml:"urn:ietf:params:xml:ns:yang:ietf-netconf-nmda max-depth,omitempty"` // nil: unbounded
+	WithOrigin          *struct{}         `xml:"urn:ietf:params:xml:ns:yang:ietf-netconf-nmda with-origin,omitempty"`
+	WithDefaults        DefaultsMode      `xml:"urn:ietf:params:xml:ns:yang:ietf-netconf-with-defaults with-defaults,omitempty"`
+}
+
+// EditData defines the <edit-data> operation (RFC 8526) for use with Session.CallSimple
+type EditData struct {
+	XMLName          xml.Name          `xml:"urn:ietf:params:xml:ns:yang:ietf-netconf-nmda edit-data"`
+	Datastore        DatastoreIdentity `xml:"urn:ietf:params:xml:ns:yang:ietf-netconf-nmda datastore"`
+	DefaultOperation *DefaultOperation `xml:"urn:ietf:params:xml:ns:yang:ietf-netconf-nmda default-operation,omitempty"`
+	Config           struct {
+		InnerXML []byte `xml:",innerxml"`
+	} `xml:"urn:ietf:params:xml:ns:yang:ietf-netconf-nmda config"`
+}
+
+// OriginAnnotation describes an or:origin annotation of an element in a <get-data> reply.
+// Descendants of the element without own annotation share its origin.
+type OriginAnnotation struct {
+	Path   []xml.Name
+	Origin OriginIdentity
+}
+
+// ParseOrigins extracts all or:origin annotations from the data of a <get-data> reply or a whole <rpc-reply>.
+// Namespace declarations of enclosing elements not contained in the data, e.g. RPCReplyData.Namespaces(),
+// must be passed as well to resolve prefixes declared on <data> or <rpc-reply>.
+func ParseOrigins(data []byte, namespaces ...xml.Attr) ([]OriginAnnotation, error) {
+	var annotations []OriginAnnotation
+	var path []xml.Name
+	scopes := []map[string]string{declaredPrefixes(namespaces)} // Namespace prefix declarations per element
+
+	// Namespaces are resolved here as the decoder does not know the declarations of enclosing elements
+	resolve := func(prefix string) (string, bool) {
+		for i := len(scopes) - 1; i >= 0; i-- {
+			if namespace, ok := scopes[i][prefix]; ok {
+				return namespace, true
+			}
+		}
+		return prefix, false
+	}
+
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	for {
+		token, err := decoder.RawToken()
+		if err == io.EOF {
+			return annotations, nil
+		} else if err != nil {
+			return nil, err
+		}
+
+		switch element := token.(type) {
+		case xml.StartElement:
+			scopes = append(scopes, declaredPrefixes(element.Attr))
+			name := element.Name
+			name.Space, _ = resolve(name.Space)
+			path = append(path, name)
+
+			for _, attr := range element.Attr {
+				if len(attr.Name.Space) == 0 || attr.Name.Local != "origin" {
+					continue
+				} else if namespace, _ := resolve(attr.Name.Space); namespace != NsOrigin {
+					continue
+				}
+
+				origin := OriginIdentity{Namespace: NsOrigin, Name: strings.TrimSpace(attr.Value)}
+				if parts := strings.SplitN(origin.Name, ":", 2); len(parts) > 1 {
+					origin.Name = parts[1]
+					if namespace, ok := resolve(parts[0]); ok {
+						origin.Namespace = namespace
+					}
+				}
+
+				annotations = append(annotations, OriginAnnotation{
+					Path:   append([]xml.Name(nil), path...),
+					Origin: origin,
+				})
+			}
+		case xml.EndElement:
+			path = path[:len(path)-1]
+			scopes = scopes[:len(scopes)-1]
+		}
+	}
+}
+
+// declaredPrefixes returns the namespace declarations of the given attributes by prefix, "" for the default namespace
+func declaredPrefixes(attrs []xml.Attr) map[string]string {
+	declared := make(map[string]string)
+	for _, attr := range attrs {
+		if attr.Name.Space == "xmlns" {
+			declared[attr.Name.Local] = attr.Value
+		} else if attr.Name.Space == "" && attr.Name.Local == "xmlns" {
+			declared[""] = attr.Value
+		}
+	}
+	return declared
+}
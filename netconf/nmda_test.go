@@ -0,0 +1,67 @@
+/**
+ * Copyright (c) 2019-2020 Cisco Systems
+ *
+ * Author: Steven Barth <stbarth@cisco.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package netconf
+
+import (
+	"encoding/xml"
+	"reflect"
+	"testing"
+)
+
+// Origin prefix declared on <data> as most servers do
+const testOriginReply = `<rpc-reply xmlns="urn:ietf:params:xml:ns:netconf:base:1.0" message-id="1"
+    xmlns:ex="urn:example:origin"><data xmlns:or="urn:ietf:params:xml:ns:yang:ietf-origin">` +
+	`<interfaces xmlns="urn:ietf:params:xml:ns:yang:ietf-interfaces" or:origin="or:intended">` +
+	`<interface><name>eth0</name><enabled or:origin="ex:vendor">true</enabled></interface>` +
+	`<interface or:origin="system"><name>lo</name></interface>` +
+	`</interfaces></data></rpc-reply>`
+
+func TestParseOrigins(t *testing.T) {
+	const nsInterfaces = "urn:ietf:params:xml:ns:yang:ietf-interfaces"
+	expected := []OriginAnnotation{
+		{Path: []xml.Name{{Space: nsInterfaces, Local: "interfaces"}}, Origin: OriginIntended},
+		{Path: []xml.Name{{Space: nsInterfaces, Local: "interfaces"}, {Space: nsInterfaces, Local: "interface"},
+			{Space: nsInterfaces, Local: "enabled"}}, Origin: OriginIdentity{Namespace: "urn:example:origin", Name: "vendor"}},
+		{Path: []xml.Name{{Space: nsInterfaces, Local: "interfaces"}, {Space: nsInterfaces, Local: "interface"}},
+			Origin: OriginSystem},
+	}
+
+	reply := &RPCReplyData{}
+	if err := xml.Unmarshal([]byte(testOriginReply), reply); err != nil {
+		t.Fatal(err)
+	}
+
+	if origins, err := ParseOrigins(reply.Data.InnerXML, reply.Namespaces()...); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(origins, expected) {
+		t.Fatalf("Unexpected origins %+v", origins)
+	}
+
+	// The whole reply carries its own declarations
+	if origins, err := ParseOrigins([]byte(testOriginReply)); err != nil {
+		t.Fatal(err)
+	} else if len(origins) != 3 || !reflect.DeepEqual(origins[1].Origin, expected[1].Origin) {
+		t.Fatalf("Unexpected origins %+v", origins)
+	}
+
+	// Without the enclosing declarations the annotations cannot be identified
+	if origins, err := ParseOrigins(reply.Data.InnerXML); err != nil || len(origins) != 0 {
+		t.Fatalf("Unexpected origins %+v: %v", origins, err)
+	}
+}
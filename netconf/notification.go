@@ -0,0 +1,134 @@
+/**
+ * Copyright (c) 2019-2020 Cisco Systems
+ *
+ * Author: Steven Barth <stbarth@cisco.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package netconf
+
+import (
+	"bytes"
+	"encoding/xml"
+	"time"
+)
+
+// RawNotification is a received NETCONF notification with its parsed event time
+type RawNotification struct {
+	EventTime time.Time
+	XML       []byte // complete <notification> element
+}
+
+// Decode the notification into a struct, e.g. one embedding Notification
+func (n *RawNotification) Decode(v interface{}) error {
+	return xml.Unmarshal(n.XML, v)
+}
+
+// maxNotificationBacklog is the number of notifications retained for Receive, older ones are dropped
+const maxNotificationBacklog = 1024
+
+// NotificationHandler is called for every notification received on a session
+type NotificationHandler func(*RawNotification)
+
+type notificationHandlerEntry struct {
+	handler NotificationHandler
+}
+
+// OnNotification registers a handler for notifications received on the session and returns a function to remove it.
+// Handlers are called from the goroutine reading the session in sequential mode, i.e. the caller of Call or Receive,
+// or from a dispatching goroutine in multiplexed mode, and must not block or call the session themselves.
+// Notifications are only returned by Receive if no handlers are registered when they are read, in that case
+// up to 1024 of them are retained while calling other RPCs and older ones are dropped.
+func (s *Session) OnNotification(handler NotificationHandler) func() {
+	entry := &notificationHandlerEntry{handler: handler}
+	s.notificationMutex.Lock()
+	s.notificationHandlers = append(s.notificationHandlers, entry)
+	s.notificationMutex.Unlock()
+
+	return func() {
+		s.notificationMutex.Lock()
+		defer s.notificationMutex.Unlock()
+		for i, e := range s.notificationHandlers {
+			if e == entry {
+				s.notificationHandlers = append(s.notificationHandlers[:i], s.notificationHandlers[i+1:]...)
+				break
+			}
+		}
+	}
+}
+
+// Notifications registers a channel-based notification handler with the given buffer size and returns the
+// channel and a function to remove the handler. Notifications are dropped while the channel is full
+// so that a slow consumer never delays replies.
+func (s *Session) Notifications(size int) (<-chan *RawNotification, func()) {
+	notifications := make(chan *RawNotification, size)
+	return notifications, s.OnNotification(func(notification *RawNotification) {
+		select {
+		case notifications <- notification:
+		default:
+		}
+	})
+}
+
+// hasNotificationHandlers returns true if any notification handlers are registered
+func (s *Session) hasNotificationHandlers() bool {
+	s.notificationMutex.Lock()
+	defer s.notificationMutex.Unlock()
+	return len(s.notificationHandlers) > 0
+}
+
+// dispatchNotification passes a notification to registered handlers and returns false if there are none
+func (s *Session) dispatchNotification(data []byte) bool {
+	s.notificationMutex.Lock()
+	handlers := make([]NotificationHandler, len(s.notificationHandlers))
+	for i, entry := range s.notificationHandlers {
+		handlers[i] = entry.handler
+	}
+	s.notificationMutex.Unlock()
+
+	if len(handlers) == 0 {
+		return false
+	}
+
+	header := &Notification{}
+	xml.Unmarshal(data, header)
+	notification := &RawNotification{EventTime: header.EventTime, XML: data}
+	for _, handler := range handlers {
+		handler(notification)
+	}
+	return true
+}
+
+// handleNotification passes a notification to registered handlers or retains it for Receive in sequential mode
+func (s *Session) handleNotification(data []byte) {
+	if s.dispatchNotification(data) {
+		return
+	} else if len(s.notificationBacklog) >= maxNotificationBacklog {
+		s.notificationBacklog = append(s.notificationBacklog[:0], s.notificationBacklog[1:]...)
+	}
+	s.notificationBacklog = append(s.notificationBacklog, data)
+}
+
+// captureBuffer records the beginning of a message until disabled
+type captureBuffer struct {
+	bytes.Buffer
+	disabled bool
+}
+
+func (c *captureBuffer) Write(p []byte) (int, error) {
+	if !c.disabled {
+		return c.Buffer.Write(p)
+	}
+	return len(p), nil
+}
@@ -0,0 +1,147 @@
+/**
+ * Copyright (c) 2019-2020 Cisco Systems
+ *
+ * Author: Steven Barth <stbarth@cisco.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package netconf
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// testPeer exchanges raw NETCONF 1.0 messages with a session without hello exchange
+type testPeer struct {
+	conn   net.Conn
+	reader *messageReader
+}
+
+func newTestPeer(t *testing.T) (*Session, *testPeer) {
+	client, conn := net.Pipe()
+	session := &Session{
+		transport:   client,
+		reader:      newMessageReader(client),
+		newFramer:   newFramerV10,
+		newUnframer: newUnframerV10,
+	}
+	t.Cleanup(func() {
+		client.Close()
+		conn.Close()
+	})
+	return session, &testPeer{conn: conn, reader: newMessageReader(conn)}
+}
+
+// read returns the next message sent by the session
+func (p *testPeer) read() (string, error) {
+	data, err := io.ReadAll(newUnframerV10(p.reader, 0))
+	return string(data), err
+}
+
+// write sends messages to the session
+func (p *testPeer) write(messages ...string) error {
+	for _, message := range messages {
+		if _, err := p.conn.Write([]byte(message + "]]>]]>")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func testNotification(id int) string {
+	return fmt.Sprintf(`<notification xmlns="urn:ietf:params:xml:ns:netconf:notification:1.0">`+
+		`<eventTime>2024-01-01T00:00:00Z</eventTime><event xmlns="urn:example"><id>%d</id></event></notification>`, id)
+}
+
+// testEvent decodes notifications created by testNotification
+type testEvent struct {
+	XMLName xml.Name `xml:"urn:ietf:params:xml:ns:netconf:notification:1.0 notification"`
+	ID      int      `xml:"urn:example event>id"`
+}
+
+func TestNotificationsSequential(t *testing.T) {
+	session, peer := newTestPeer(t)
+	go func() {
+		peer.read()
+		for i := 0; i < maxNotificationBacklog+6; i++ {
+			peer.write(testNotification(i))
+		}
+		peer.write(`<rpc-reply xmlns="urn:ietf:params:xml:ns:netconf:base:1.0" message-id="1"><ok/></rpc-reply>`)
+		peer.read()
+		peer.write(testNotification(-1), testNotification(-2),
+			`<rpc-reply xmlns="urn:ietf:params:xml:ns:netconf:base:1.0" message-id="2"><ok/></rpc-reply>`,
+			testNotification(-3), `<hello xmlns="urn:ietf:params:xml:ns:netconf:base:1.0"/>`)
+	}()
+
+	// Without handlers notifications received during a call are retained dropping the oldest
+	if err := session.CallSimple(&Lock{Target: Running}); err != nil {
+		t.Fatal(err)
+	} else if len(session.notificationBacklog) != maxNotificationBacklog {
+		t.Fatalf("Unexpected backlog of %d notifications", len(session.notificationBacklog))
+	}
+	event := &testEvent{}
+	if err := session.Receive(event); err != nil || event.ID != 6 {
+		t.Fatalf("Unexpected notification %d: %v", event.ID, err)
+	}
+	session.notificationBacklog = nil
+
+	// A slow consumer of the notification channel does not block receiving
+	notifications, remove := session.Notifications(1)
+	defer remove()
+	if err := session.CallSimple(&Unlock{Target: Running}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Receive passes notifications to handlers just like Call
+	hello := &helloMessage{}
+	if err := session.Receive(hello); err != nil {
+		t.Fatal(err)
+	} else if len(notifications) != 1 {
+		t.Fatalf("Unexpected %d notifications", len(notifications))
+	} else if err := (<-notifications).Decode(event); err != nil || event.ID != -1 {
+		t.Fatalf("Unexpected notification %d: %v", event.ID, err)
+	}
+}
+
+func TestNotificationsMultiplexed(t *testing.T) {
+	session, peer := newTestPeer(t)
+	session.Multiplex()
+	go func() {
+		for i := 0; i < maxNotificationBacklog+6; i++ {
+			peer.write(testNotification(i))
+		}
+		peer.read()
+		peer.write(`<rpc-reply xmlns="urn:ietf:params:xml:ns:netconf:base:1.0" message-id="1"><ok/></rpc-reply>`)
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// Retained notifications are limited, the oldest are dropped
+	if err := session.CallSimpleContext(ctx, &Lock{Target: Running}); err != nil {
+		t.Fatal(err)
+	}
+	for i := 6; i < maxNotificationBacklog+6; i++ {
+		event := &testEvent{}
+		if err := session.ReceiveContext(ctx, event); err != nil || event.ID != i {
+			t.Fatalf("Unexpected notification %d instead of %d: %v", event.ID, i, err)
+		}
+	}
+}
@@ -29,16 +29,26 @@ import (
 type RPCReply struct {
 	XMLName  xml.Name   `xml:"urn:ietf:params:xml:ns:netconf:base:1.0 rpc-reply"`
 	RPCError []RPCError `xml:"rpc-error"`
+	OK       *struct{}  `xml:"urn:ietf:params:xml:ns:netconf:base:1.0 ok"`
+
+	kind ReplyKind
 }
 
 // RPCReplyData models a NETCONF <rpc-reply> element with a data child
 type RPCReplyData struct {
 	RPCReply
-	Data struct {
-		InnerXML []byte `xml:",innerxml"`
+	Attrs []xml.Attr `xml:",any,attr"`
+	Data  struct {
+		Attrs    []xml.Attr `xml:",any,attr"`
+		InnerXML []byte     `xml:",innerxml"`
 	} `xml:"data"`
 }
 
+// Namespaces returns the namespace declarations of <rpc-reply> and <data> which are in scope of the data
+func (r *RPCReplyData) Namespaces() []xml.Attr {
+	return namespaceDeclarations(namespaceDeclarations(nil, xml.StartElement{Attr: r.Attrs}), xml.StartElement{Attr: r.Data.Attrs})
+}
+
 // RPCError models a NETCONF <rpc-error> element
 type RPCError struct {
 	XMLName       xml.Name `xml:"rpc-error"`
@@ -116,7 +126,7 @@ type Lock struct {
 
 // Unlock defines the <unlock> operation for use with Session.CallProcedure
 type Unlock struct {
-	XMLName xml.Name  `xml:"urn:ietf:params:xml:ns:netconf:base:1.0 lock"`
+	XMLName xml.Name  `xml:"urn:ietf:params:xml:ns:netconf:base:1.0 unlock"`
 	Target  Datastore `xml:"urn:ietf:params:xml:ns:netconf:base:1.0 target"`
 }
 
@@ -138,6 +148,7 @@ type CommitConfirmed struct {
 	Confirmed      struct{} `xml:"urn:ietf:params:xml:ns:netconf:base:1.0 confirmed"`
 	ConfirmTimeout *uint    `xml:"urn:ietf:params:xml:ns:netconf:base:1.0 confirm-timeout,omitempty"`
 	Persist        *string  `xml:"urn:ietf:params:xml:ns:netconf:base:1.0 persist,omitempty"`
+	PersistID      *string  `xml:"urn:ietf:params:xml:ns:netconf:base:1.0 persist-id,omitempty"`
 }
 
 // CancelCommit defines the <cancel-commit> operation for use with Session.CallProcedure
@@ -220,6 +231,77 @@ type NCSCommitParameter string
 // Datastore on NETCONF agent
 type Datastore string
 
+// DatastoreIdentity identifies an NMDA datastore (RFC 8342) by its YANG identity
+type DatastoreIdentity struct {
+	Namespace string
+	Name      string
+}
+
+// List of datastore identities defined in ietf-datastores
+var (
+	DatastoreRunning     = DatastoreIdentity{Namespace: NsDatastores, Name: "running"}
+	DatastoreCandidate   = DatastoreIdentity{Namespace: NsDatastores, Name: "candidate"}
+	DatastoreStartup     = DatastoreIdentity{Namespace: NsDatastores, Name: "startup"}
+	DatastoreIntended    = DatastoreIdentity{Namespace: NsDatastores, Name: "intended"}
+	DatastoreOperational = DatastoreIdentity{Namespace: NsDatastores, Name: "operational"}
+)
+
+// MarshalXML datastore identity as prefixed identityref
+func (d DatastoreIdentity) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	return marshalIdentity(e, start, "ds", d.Namespace, d.Name)
+}
+
+// UnmarshalXML datastore identity from a prefixed identityref.
+// Prefixes not declared on the element itself are assumed to refer to ietf-datastores.
+func (d *DatastoreIdentity) UnmarshalXML(decoder *xml.Decoder, start xml.StartElement) error {
+	var err error
+	d.Namespace, d.Name, err = unmarshalIdentity(decoder, start, NsDatastores)
+	return err
+}
+
+func marshalIdentity(e *xml.Encoder, start xml.StartElement, prefix string, namespace string, name string) error {
+	start.Attr = append(start.Attr, xml.Attr{Name: xml.Name{Local: "xmlns:" + prefix}, Value: namespace})
+	return e.EncodeElement(prefix+":"+name, start)
+}
+
+func unmarshalIdentity(decoder *xml.Decoder, start xml.StartElement, defaultNamespace string) (string, string, error) {
+	var value string
+	if err := decoder.DecodeElement(&value, &start); err != nil {
+		return "", "", err
+	}
+
+	namespace, name := defaultNamespace, strings.TrimSpace(value)
+	if parts := strings.SplitN(name, ":", 2); len(parts) > 1 {
+		name = parts[1]
+		for _, attr := range start.Attr {
+			if attr.Name.Space == "xmlns" && attr.Name.Local == parts[0] {
+				namespace = attr.Value
+			}
+		}
+	}
+	return namespace, name, nil
+}
+
+// UnmarshalXML datastore from XML, URLs are returned as they are
+func (d *Datastore) UnmarshalXML(decoder *xml.Decoder, start xml.StartElement) error {
+	element := &struct {
+		URL       string `xml:"urn:ietf:params:xml:ns:netconf:base:1.0 url"`
+		Datastore []struct {
+			XMLName xml.Name
+		} `xml:",any"`
+	}{}
+	if err := decoder.DecodeElement(element, &start); err != nil {
+		return err
+	}
+
+	if len(element.URL) > 0 {
+		*d = Datastore(element.URL)
+	} else if len(element.Datastore) > 0 {
+		*d = Datastore(element.Datastore[0].XMLName.Local)
+	}
+	return nil
+}
+
 // MarshalXML datastore into XML depending if it is a URL (contains a ':') or not
 func (d Datastore) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
 	var element interface{}
@@ -0,0 +1,460 @@
+/**
+ * Copyright (c) 2019-2020 Cisco Systems
+ *
+ * Author: Steven Barth <stbarth@cisco.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package netconf
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Directions of recorded data
+const (
+	RecordIn  = "in"  // Received from the device
+	RecordOut = "out" // Sent to the device
+)
+
+// ErrRecordUnsupported indicates that a client does not support recording its sessions
+var ErrRecordUnsupported = errors.New("Client does not support recording sessions")
+
+// RecordEntry is the data of a single read or write on a session transport. Framing is recorded as is,
+// replay compares sent messages without it.
+type RecordEntry struct {
+	Session   int       // Index of the session created by a recording client
+	Time      time.Time // Time of the read or write
+	Direction string    // RecordIn or RecordOut
+	Data      []byte
+}
+
+// recordEntryJSON is the JSON form of a RecordEntry, data which is not valid UTF-8 is stored as base64
+type recordEntryJSON struct {
+	Session   int       `json:"session"`
+	Time      time.Time `json:"time"`
+	Direction string    `json:"direction"`
+	Text      *string   `json:"text,omitempty"`
+	Base64    []byte    `json:"base64,omitempty"`
+}
+
+// MarshalJSON stores the data as text if possible to keep recordings readable
+func (e RecordEntry) MarshalJSON() ([]byte, error) {
+	entry := recordEntryJSON{Session: e.Session, Time: e.Time, Direction: e.Direction}
+	if utf8.Valid(e.Data) {
+		text := string(e.Data)
+		entry.Text = &text
+	} else {
+		entry.Base64 = e.Data
+	}
+	return json.Marshal(&entry)
+}
+
+// UnmarshalJSON reads entries with either text or base64 data
+func (e *RecordEntry) UnmarshalJSON(data []byte) error {
+	entry := recordEntryJSON{}
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return err
+	}
+
+	*e = RecordEntry{Session: entry.Session, Time: entry.Time, Direction: entry.Direction, Data: entry.Base64}
+	if entry.Text != nil {
+		e.Data = []byte(*entry.Text)
+	}
+	if e.Direction != RecordIn && e.Direction != RecordOut {
+		return fmt.Errorf("Invalid record direction %q", e.Direction)
+	}
+	return nil
+}
+
+// recorder writes entries of one or more sessions as JSON lines
+type recorder struct {
+	mutex   sync.Mutex
+	encoder *json.Encoder
+	err     error
+	next    int
+}
+
+func (r *recorder) record(session int, direction string, data []byte) {
+	entry := RecordEntry{Session: session, Time: time.Now(), Direction: direction, Data: data}
+	r.mutex.Lock()
+	if r.err == nil {
+		r.err = r.encoder.Encode(entry)
+	}
+	r.mutex.Unlock()
+}
+
+// recordingTransport records all data read and written by a transport
+type recordingTransport struct {
+	transport io.ReadWriteCloser
+	recorder  *recorder
+	session   int
+}
+
+// NewRecordingTransport wraps a transport, recording all data passing it as JSON lines to the writer
+func NewRecordingTransport(transport io.ReadWriteCloser, record io.Writer) io.ReadWriteCloser {
+	return &recordingTransport{transport: transport, recorder: &recorder{encoder: json.NewEncoder(record)}}
+}
+
+func (t *recordingTransport) Read(p []byte) (int, error) {
+	n, err := t.transport.Read(p)
+	if n > 0 {
+		t.recorder.record(t.session, RecordIn, p[:n])
+	}
+	return n, err
+}
+
+func (t *recordingTransport) Write(p []byte) (int, error) {
+	n, err := t.transport.Write(p)
+	if n > 0 {
+		t.recorder.record(t.session, RecordOut, p[:n])
+	}
+	return n, err
+}
+
+func (t *recordingTransport) Close() error {
+	return t.transport.Close()
+}
+
+// transportWrapper wraps the transport of a session before it is created, nil leaves it unchanged
+type transportWrapper func(io.ReadWriteCloser) io.ReadWriteCloser
+
+func (wrap transportWrapper) apply(transport io.ReadWriteCloser) io.ReadWriteCloser {
+	if wrap == nil {
+		return transport
+	}
+	return wrap(transport)
+}
+
+// wrappingClient is implemented by clients supporting wrapped session transports and session options
+type wrappingClient interface {
+	newSessionWrapped(ctx context.Context, wrap transportWrapper, options SessionOptions) (*Session, error)
+}
+
+// recordingClient records all sessions of a client numbering them in order of creation
+type recordingClient struct {
+	Client
+	wrapping wrappingClient
+	recorder *recorder
+}
+
+// RecordClient returns a client recording all its sessions as JSON lines to the writer.
+// Entries are tagged with the index of their session in order of creation starting at 0.
+func RecordClient(client Client, record io.Writer) (Client, error) {
+	if callHome, ok := client.(*CallHomeClient); ok {
+		client = callHome.Client
+	}
+
+	wrapping, ok := client.(wrappingClient)
+	if !ok {
+		return nil, ErrRecordUnsupported
+	}
+	return &recordingClient{Client: client, wrapping: wrapping, recorder: &recorder{encoder: json.NewEncoder(record)}}, nil
+}
+
+// NewSession creates a new recorded session
+func (c *recordingClient) NewSession() (*Session, error) {
+	return c.NewSessionContext(context.Background())
+}
+
+// NewSessionContext creates a new recorded session, aborting if the context ends first
+func (c *recordingClient) NewSessionContext(ctx context.Context) (*Session, error) {
+	return c.newSessionWrapped(ctx, nil, SessionOptions{})
+}
+
+func (c *recordingClient) newSessionWrapped(ctx context.Context, wrap transportWrapper, options SessionOptions) (*Session, error) {
+	return c.wrapping.newSessionWrapped(ctx, func(transport io.ReadWriteCloser) io.ReadWriteCloser {
+		c.recorder.mutex.Lock()
+		session := c.recorder.next
+		c.recorder.next++
+		c.recorder.mutex.Unlock()
+		return wrap.apply(&recordingTransport{transport: transport, recorder: c.recorder, session: session})
+	}, options)
+}
+
+// ReadRecording reads all entries of a recording
+func ReadRecording(reader io.Reader) ([]RecordEntry, error) {
+	var entries []RecordEntry
+	decoder := json.NewDecoder(bufio.NewReader(reader))
+	for {
+		entry := RecordEntry{}
+		if err := decoder.Decode(&entry); err == io.EOF {
+			return entries, nil
+		} else if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+}
+
+// ReplayMismatchError indicates that a message sent during replay differs from the recording
+type ReplayMismatchError struct {
+	Message  int    // Index of the sent message, the hello being 0
+	Offset   int    // Offset of the first difference within the message
+	Expected []byte // Remaining recorded message, empty if no more messages were recorded
+	Received []byte
+}
+
+func (e *ReplayMismatchError) Error() string {
+	const maxLen = 64
+	expected, received := e.Expected, e.Received
+	if len(expected) > maxLen {
+		expected = expected[:maxLen]
+	}
+	if len(received) > maxLen {
+		received = received[:maxLen]
+	}
+	return fmt.Sprintf("Replay mismatch in message %d at offset %d: expected %q, received %q", e.Message, e.Offset, expected, received)
+}
+
+// ReplayOptions defines how recorded sessions are replayed
+type ReplayOptions struct {
+	Realtime bool // Delay received data by the recorded time since the preceding entry
+}
+
+// replayTransport plays back received data once all messages recorded to be sent before it were written.
+// Sent messages are compared without their framing so that e.g. a different chunk size does not matter.
+type replayTransport struct {
+	mutex    sync.Mutex
+	cond     *sync.Cond
+	in       []replayChunk
+	out      [][]byte // Messages expected to be sent
+	options  ReplayOptions
+	pending  []byte    // Written data not forming a complete message yet
+	chunked  bool      // Whether written messages use chunked framing
+	received int       // Index of the next received chunk
+	offset   int       // Offset within the next received chunk
+	written  int       // Number of messages written
+	last     time.Time // Time of the last write or received chunk
+	closed   chan struct{}
+	err      error
+}
+
+// replayChunk is recorded received data, the number of messages sent before it and its delay
+type replayChunk struct {
+	data  []byte
+	sent  int
+	delay time.Duration
+}
+
+// NewReplayTransport creates a transport replaying recorded entries of a single session.
+// Written messages must match the recording, received data is played back in recorded order.
+func NewReplayTransport(entries []RecordEntry, options ReplayOptions) io.ReadWriteCloser {
+	var in, out []byte
+	for _, entry := range entries {
+		if entry.Direction == RecordOut {
+			out = append(out, entry.Data...)
+		} else {
+			in = append(in, entry.Data...)
+		}
+	}
+
+	// Messages after the hellos are chunked if the server supports base:1.1
+	chunked := false
+	if hello, _, err := splitMessage(in, false); err == nil && hello != nil {
+		peer := &helloMessage{}
+		if xml.Unmarshal(hello, peer) == nil {
+			for _, capability := range peer.Capabilities {
+				chunked = chunked || capability == CapNetconf11
+			}
+		}
+	}
+
+	t := &replayTransport{options: options, chunked: chunked, last: time.Now(), closed: make(chan struct{})}
+	t.cond = sync.NewCond(&t.mutex)
+	var sent []byte
+	var previous time.Time
+	for _, entry := range entries {
+		if entry.Direction == RecordOut {
+			sent = append(sent, entry.Data...)
+			for {
+				message, n, err := splitMessage(sent, chunked && len(t.out) > 0)
+				if err != nil || message == nil {
+					break
+				}
+				t.out = append(t.out, message)
+				sent = sent[n:]
+			}
+		} else if len(entry.Data) > 0 {
+			var delay time.Duration
+			if !previous.IsZero() && entry.Time.After(previous) {
+				delay = entry.Time.Sub(previous)
+			}
+			t.in = append(t.in, replayChunk{data: entry.Data, sent: len(t.out), delay: delay})
+		}
+		previous = entry.Time
+	}
+	return t
+}
+
+// splitMessage returns the first complete message of framed data and the length of its framing.
+// The message is nil if the data does not contain a complete message yet.
+func splitMessage(data []byte, chunked bool) ([]byte, int, error) {
+	if !chunked {
+		if i := bytes.Index(data, []byte("]]>]]>")); i >= 0 {
+			return data[:i], i + 6, nil
+		}
+		return nil, 0, nil
+	}
+
+	message := []byte{}
+	for offset := 0; ; {
+		header := data[offset:]
+		if len(header) >= 2 && (header[0] != '\n' || header[1] != '#') {
+			return nil, 0, ErrFraming
+		} else if len(header) < 4 {
+			return nil, 0, nil
+		} else if header[2] == '#' {
+			if header[3] != '\n' {
+				return nil, 0, ErrFraming
+			}
+			return message, offset + 4, nil
+		}
+
+		// Chunk sizes have at most 10 digits
+		end := bytes.IndexByte(header[2:], '\n') + 2
+		if end < 2 {
+			if len(header) > 12 {
+				return nil, 0, ErrFraming
+			}
+			return nil, 0, nil
+		}
+
+		size, err := strconv.ParseUint(string(header[2:end]), 10, 32)
+		if err != nil || size == 0 {
+			return nil, 0, ErrFraming
+		} else if len(header) < end+1+int(size) {
+			return nil, 0, nil
+		}
+		message = append(message, header[end+1:end+1+int(size)]...)
+		offset += end + 1 + int(size)
+	}
+}
+
+// ReplaySession creates a session replaying the recorded session with the given index
+func ReplaySession(ctx context.Context, entries []RecordEntry, session int, options ReplayOptions) (*Session, error) {
+	var filtered []RecordEntry
+	for _, entry := range entries {
+		if entry.Session == session {
+			filtered = append(filtered, entry)
+		}
+	}
+	return newSession(ctx, NewReplayTransport(filtered, options), SessionOptions{})
+}
+
+func (t *replayTransport) Read(p []byte) (int, error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	for {
+		if t.err != nil {
+			return 0, t.err
+		} else if t.received >= len(t.in) {
+			return 0, io.EOF
+		} else if chunk := t.in[t.received]; t.written >= chunk.sent {
+			if wait := time.Until(t.last.Add(chunk.delay)); t.options.Realtime && t.offset == 0 && wait > 0 {
+				t.mutex.Unlock()
+				select {
+				case <-time.After(wait):
+				case <-t.closed:
+				}
+				t.mutex.Lock()
+				continue
+			}
+
+			n := copy(p, chunk.data[t.offset:])
+			if t.offset += n; t.offset == len(chunk.data) {
+				t.received++
+				t.offset = 0
+				t.last = time.Now()
+			}
+			return n, nil
+		}
+		t.cond.Wait()
+	}
+}
+
+func (t *replayTransport) Write(p []byte) (int, error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if t.err != nil {
+		return 0, t.err
+	}
+
+	t.pending = append(t.pending, p...)
+	t.last = time.Now()
+	for {
+		message, n, err := splitMessage(t.pending, t.chunked && t.written > 0)
+		if err != nil {
+			t.err = err
+		} else if message == nil {
+			break
+		} else if err := t.compare(message); err != nil {
+			t.err = err
+		}
+		if t.err != nil {
+			t.cond.Broadcast()
+			return 0, t.err
+		}
+
+		t.pending = t.pending[n:]
+		t.written++
+		t.cond.Broadcast()
+	}
+	return len(p), nil
+}
+
+// compare a written message with the next recorded one
+func (t *replayTransport) compare(message []byte) error {
+	var expected []byte
+	if t.written < len(t.out) {
+		expected = t.out[t.written]
+	}
+
+	i := 0
+	for i < len(message) && i < len(expected) && message[i] == expected[i] {
+		i++
+	}
+	if i == len(message) && i == len(expected) {
+		return nil
+	}
+	return &ReplayMismatchError{Message: t.written, Offset: i, Expected: expected[i:], Received: append([]byte(nil), message[i:]...)}
+}
+
+func (t *replayTransport) Close() error {
+	t.mutex.Lock()
+	if t.err == nil {
+		t.err = io.ErrClosedPipe
+	}
+	select {
+	case <-t.closed:
+	default:
+		close(t.closed)
+	}
+	t.cond.Broadcast()
+	t.mutex.Unlock()
+	return nil
+}
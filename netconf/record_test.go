@@ -0,0 +1,133 @@
+/**
+ * Copyright (c) 2019-2020 Cisco Systems
+ *
+ * Author: Steven Barth <stbarth@cisco.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package netconf
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestRecordReplay(t *testing.T) {
+	server := NewServer()
+	server.Handle(xml.Name{Space: NsNetconf, Local: "get-config"}, func(s *ServerSession, r *ServerRequest) (interface{}, error) {
+		return &DataContent{InnerXML: []byte("<top xmlns=\"urn:example\">value</top>")}, nil
+	})
+
+	client, err := DialSSHWithPassword(startTestServer(t, server), "user", "", ssh.InsecureIgnoreHostKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	var record bytes.Buffer
+	if client, err = RecordClient(client, &record); err != nil {
+		t.Fatal(err)
+	}
+
+	// Record two sessions, the second one being replayed
+	for i := 0; i < 2; i++ {
+		session, err := client.NewSession()
+		if err != nil {
+			t.Fatal(err)
+		} else if err = session.Call(&GetConfig{Source: Running}, &RPCReplyData{}); err != nil {
+			t.Fatal(err)
+		} else if err = session.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	entries, err := ReadRecording(&record)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(entries) == 0 || entries[len(entries)-1].Session != 1 {
+		t.Fatalf("Unexpected recording with %d entries", len(entries))
+	}
+
+	session, err := ReplaySession(context.Background(), entries, 1, ReplayOptions{})
+	if err != nil {
+		t.Fatal(err)
+	} else if session.SessionID != 2 {
+		t.Fatalf("Unexpected session-id %d", session.SessionID)
+	}
+
+	reply := &RPCReplyData{}
+	if err = session.Call(&GetConfig{Source: Running}, reply); err != nil {
+		t.Fatal(err)
+	} else if string(reply.Data.InnerXML) != "<top xmlns=\"urn:example\">value</top>" {
+		t.Fatalf("Unexpected reply %q", reply.Data.InnerXML)
+	} else if err = session.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Data which is not valid UTF-8 is preserved
+	var buffer bytes.Buffer
+	if err = json.NewEncoder(&buffer).Encode(RecordEntry{Direction: RecordIn, Data: []byte("\xff]]>]]>")}); err != nil {
+		t.Fatal(err)
+	} else if decoded, err := ReadRecording(&buffer); err != nil || len(decoded) != 1 || string(decoded[0].Data) != "\xff]]>]]>" {
+		t.Fatalf("Unexpected entries %v: %v", decoded, err)
+	}
+
+	// Sending anything else than recorded fails
+	session, err = ReplaySession(context.Background(), entries, 0, ReplayOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mismatch *ReplayMismatchError
+	if err = session.CallSimple(&Lock{Target: Running}); !errors.As(err, &mismatch) || mismatch.Message != 1 {
+		t.Fatalf("Expected replay mismatch, got %v", err)
+	}
+
+	// Messages are compared without their framing
+	var recorded []RecordEntry
+	for _, entry := range entries {
+		if entry.Session == 0 {
+			recorded = append(recorded, entry)
+		}
+	}
+	session, err = NewSessionTransport(context.Background(), NewReplayTransport(recorded, ReplayOptions{}), SessionOptions{ChunkSize: 7})
+	if err != nil {
+		t.Fatal(err)
+	} else if err = session.Call(&GetConfig{Source: Running}, &RPCReplyData{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Realtime replay delays the reply as recorded
+	for i := range recorded {
+		if recorded[i].Direction == RecordIn && bytes.Contains(recorded[i].Data, []byte("<top")) {
+			recorded[i].Time = recorded[i-1].Time.Add(200 * time.Millisecond)
+		}
+	}
+	session, err = NewSessionTransport(context.Background(), NewReplayTransport(recorded, ReplayOptions{Realtime: true}), SessionOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	if err = session.Call(&GetConfig{Source: Running}, &RPCReplyData{}); err != nil {
+		t.Fatal(err)
+	} else if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+		t.Fatalf("Reply replayed after %v", elapsed)
+	}
+}
@@ -0,0 +1,298 @@
+/**
+ * Copyright (c) 2019-2020 Cisco Systems
+ *
+ * Author: Steven Barth <stbarth@cisco.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package netconf
+
+import (
+	"encoding/xml"
+	"fmt"
+)
+
+// ReplyKind classifies an <rpc-reply> by its content
+type ReplyKind int
+
+// List of reply kinds
+const (
+	ReplyUnknown ReplyKind = iota // Reply was not inspected
+	ReplyOK                       // Contains <ok/>
+	ReplyData                     // Contains data, e.g. <data> or RPC output
+	ReplyError                    // Contains <rpc-error> elements
+)
+
+func (k ReplyKind) String() string {
+	switch k {
+	case ReplyOK:
+		return "ok"
+	case ReplyData:
+		return "data"
+	case ReplyError:
+		return "error"
+	}
+	return "unknown"
+}
+
+// MalformedReplyError indicates an <rpc-reply> which is neither an ok-, data- nor error-reply
+// or which does not echo the attributes of the request
+type MalformedReplyError struct {
+	MessageID string
+	Reason    string
+}
+
+func (e *MalformedReplyError) Error() string {
+	return fmt.Sprintf("Malformed NETCONF rpc-reply to message-id %q: %s", e.MessageID, e.Reason)
+}
+
+// Kind returns the kind of the reply as determined when it was received
+func (r *RPCReply) Kind() ReplyKind {
+	return r.kind
+}
+
+func (r *RPCReply) setKind(kind ReplyKind) {
+	r.kind = kind
+}
+
+// rpcAttributes returns the attributes of an <rpc> element
+func (s *Session) rpcAttributes(messageID string) []xml.Attr {
+	attrs := []xml.Attr{{Name: xml.Name{Local: "message-id"}, Value: messageID}}
+	return append(attrs, s.RPCAttributes...)
+}
+
+// Scanner states
+const (
+	scanText = iota
+	scanTag
+	scanStartName
+	scanStartAttrs
+	scanStartSlash
+	scanEndTag
+	scanBang
+	scanComment
+	scanCData
+	scanDeclaration
+	scanInstruction
+)
+
+// replyScanner classifies the top-level children of an XML message by scanning its raw bytes.
+// It is fed once while the message is read and does not allocate per element.
+type replyScanner struct {
+	state    int
+	depth    int
+	maxDepth int
+	quote    byte
+	repeat   int // Number of repeated terminator characters seen, e.g. '-' for comments
+	name     [16]byte
+	nameLen  int
+	hasOK    bool
+	hasData  bool
+	errors   int  // Number of rpc-errors
+	warnings int  // Number of rpc-errors of severity warning
+	inError  bool // Inside a top-level rpc-error
+	severity bool // Inside the error-severity of an rpc-error, its text is collected in name
+}
+
+func (r *replyScanner) Write(p []byte) (int, error) {
+	for _, b := range p {
+		r.scan(b)
+	}
+	return len(p), nil
+}
+
+func (r *replyScanner) scan(b byte) {
+	switch r.state {
+	case scanText:
+		if b == '<' {
+			if r.severity && r.nameLen == len(SeverityWarning) && string(r.name[:r.nameLen]) == SeverityWarning {
+				r.warnings++
+			}
+			r.state, r.severity = scanTag, false
+		} else if r.severity && b != ' ' && b != '\t' && b != '\r' && b != '\n' {
+			r.appendName(b)
+		}
+	case scanTag:
+		switch b {
+		case '/':
+			r.state = scanEndTag
+		case '!':
+			r.state = scanBang
+		case '?':
+			r.state, r.repeat = scanInstruction, 0
+		default:
+			r.state, r.nameLen = scanStartName, 0
+			r.appendName(b)
+		}
+	case scanStartName:
+		switch b {
+		case ' ', '\t', '\r', '\n':
+			r.state = scanStartAttrs
+		case '/':
+			r.state = scanStartSlash
+		case '>':
+			r.openElement(false)
+		case ':':
+			r.nameLen = 0 // Only the local name is of interest
+		default:
+			r.appendName(b)
+		}
+	case scanStartAttrs:
+		if r.quote != 0 {
+			if b == r.quote {
+				r.quote = 0
+			}
+		} else if b == '"' || b == '\'' {
+			r.quote = b
+		} else if b == '/' {
+			r.state = scanStartSlash
+		} else if b == '>' {
+			r.openElement(false)
+		}
+	case scanStartSlash:
+		if b == '>' {
+			r.openElement(true)
+		} else {
+			r.state = scanStartAttrs
+		}
+	case scanEndTag:
+		if b == '>' {
+			r.depth--
+			r.state = scanText
+		}
+	case scanBang:
+		if b == '-' {
+			r.state, r.repeat = scanComment, 0
+		} else if b == '[' {
+			r.state, r.repeat = scanCData, 0
+		} else {
+			r.state = scanDeclaration
+		}
+	case scanComment:
+		r.state, r.repeat = r.terminate(b, '-', 2)
+	case scanCData:
+		r.state, r.repeat = r.terminate(b, ']', 2)
+	case scanInstruction:
+		r.state, r.repeat = r.terminate(b, '?', 1)
+	case scanDeclaration:
+		if b == '>' {
+			r.state = scanText
+		}
+	}
+}
+
+// terminate tracks terminators consisting of repeated characters followed by '>', e.g. "-->" or "]]>"
+func (r *replyScanner) terminate(b byte, repeated byte, count int) (int, int) {
+	if b == '>' && r.repeat >= count {
+		return scanText, 0
+	} else if b == repeated {
+		return r.state, r.repeat + 1
+	}
+	return r.state, 0
+}
+
+func (r *replyScanner) appendName(b byte) {
+	if r.nameLen < len(r.name) {
+		r.name[r.nameLen] = b
+	}
+	r.nameLen++
+}
+
+func (r *replyScanner) openElement(empty bool) {
+	if r.depth == 1 {
+		var name string
+		if r.nameLen <= len(r.name) {
+			name = string(r.name[:r.nameLen])
+		}
+
+		r.inError = name == "rpc-error"
+		switch name {
+		case "ok":
+			r.hasOK = true
+		case "rpc-error":
+			r.errors++
+		default:
+			r.hasData = true
+		}
+	} else if r.depth == 2 && r.inError && !empty && r.nameLen == len("error-severity") &&
+		string(r.name[:r.nameLen]) == "error-severity" {
+		r.severity, r.nameLen = true, 0
+	}
+	if !empty {
+		r.depth++
+		if r.depth > r.maxDepth {
+			r.maxDepth = r.depth
+		}
+	}
+	r.state = scanText
+}
+
+// kind classifies the scanned reply, rpc-errors of severity warning do not make it an error reply
+// if it also contains <ok/> or data
+func (r *replyScanner) kind(messageID string) (ReplyKind, error) {
+	switch {
+	case r.errors > r.warnings:
+		return ReplyError, nil
+	case r.hasOK && r.hasData:
+		return ReplyUnknown, &MalformedReplyError{MessageID: messageID, Reason: "both ok and data"}
+	case r.hasOK:
+		return ReplyOK, nil
+	case r.hasData:
+		return ReplyData, nil
+	case r.errors > 0:
+		return ReplyError, nil
+	}
+	return ReplyUnknown, &MalformedReplyError{MessageID: messageID, Reason: "neither ok, data nor rpc-error"}
+}
+
+// decodeReply validates and decodes an <rpc-reply> whose start element was already read.
+// The scanner must have been fed with the reply up to its end once decoding completes.
+func (s *Session) decodeReply(decoder *xml.Decoder, start xml.StartElement, messageID string,
+	response interface{}, scanner *replyScanner) error {
+	// The expected reply is still to come, the session cannot be used anymore
+	if received := attrValue(start, "message-id"); received != messageID {
+		err := &MessageIDError{Expected: messageID, Received: received}
+		s.fail(err)
+		return err
+	}
+
+	if s.StrictReplies {
+		for _, expected := range s.rpcAttributes(messageID) {
+			found := false
+			for _, attr := range start.Attr {
+				if attr.Name == expected.Name && attr.Value == expected.Value {
+					found = true
+					break
+				}
+			}
+			if !found {
+				return &MalformedReplyError{MessageID: messageID, Reason: "attribute " + expected.Name.Local + " not echoed"}
+			}
+		}
+	}
+
+	if err := decoder.DecodeElement(response, &start); err != nil {
+		return err
+	}
+
+	kind, err := scanner.kind(messageID)
+	if err != nil {
+		return err
+	}
+
+	if reply, ok := response.(interface{ setKind(ReplyKind) }); ok {
+		reply.setKind(kind)
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+/**
+ * Copyright (c) 2019-2020 Cisco Systems
+ *
+ * Author: Steven Barth <stbarth@cisco.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package netconf
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+const (
+	testWarning = `<rpc-error><error-type>application</error-type><error-tag>data-exists</error-tag>` +
+		`<error-severity> warning </error-severity></rpc-error>`
+	testError = `<rpc-error><error-type>application</error-type><error-tag>in-use</error-tag>` +
+		`<error-severity>error</error-severity></rpc-error>`
+)
+
+func TestReplyKind(t *testing.T) {
+	tests := []struct {
+		content string
+		kind    ReplyKind
+	}{
+		{`<ok/>`, ReplyOK},
+		{`<data><top xmlns="urn:example"><ok/></top></data>`, ReplyData},
+		{`<result xmlns="urn:example">1</result>`, ReplyData},
+		{testError, ReplyError},
+		{testError + `<ok/>`, ReplyError},
+		{testWarning + `<ok/>`, ReplyOK},
+		{`<nc:rpc-error xmlns:nc="urn:ietf:params:xml:ns:netconf:base:1.0"><nc:error-severity>warning</nc:error-severity></nc:rpc-error><ok/>`, ReplyOK},
+		{testWarning + `<data/>`, ReplyData},
+		{testWarning + testError + `<ok/>`, ReplyError},
+		{testWarning, ReplyError},
+		{`<!-- <rpc-error> --><![CDATA[<rpc-error>]]><ok/>`, ReplyOK},
+		{`<ok/><data/>`, ReplyUnknown},
+		{``, ReplyUnknown},
+	}
+
+	for _, multiplexed := range []bool{false, true} {
+		session, peer := newTestPeer(t)
+		if multiplexed {
+			session.Multiplex()
+		}
+
+		for _, test := range tests {
+			go func() {
+				request, _ := peer.read()
+				peer.write(`<rpc-reply xmlns="urn:ietf:params:xml:ns:netconf:base:1.0" message-id="` +
+					testMessageID.FindStringSubmatch(request)[1] + `">` + test.content + `</rpc-reply>`)
+			}()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			reply := &RPCReplyData{}
+			err := session.CallContext(ctx, &Get{}, reply)
+			cancel()
+
+			var malformed *MalformedReplyError
+			if test.kind == ReplyUnknown && !errors.As(err, &malformed) {
+				t.Fatalf("Reply %q: expected malformed reply, got %v", test.content, err)
+			} else if test.kind != ReplyUnknown && (err != nil || reply.Kind() != test.kind) {
+				t.Fatalf("Reply %q, multiplexed %t: unexpected kind %s: %v", test.content, multiplexed, reply.Kind(), err)
+			}
+		}
+	}
+}
+
+func TestReplyMessageIDMismatch(t *testing.T) {
+	for _, stream := range []bool{false, true} {
+		session, peer := newTestPeer(t)
+		go func() {
+			if request, err := peer.read(); err == nil {
+				messageID := testMessageID.FindStringSubmatch(request)[1]
+				peer.write(testReply("99", ""), testReply(messageID, ""))
+			}
+		}()
+
+		// A stray reply leaves the expected one pending, the session cannot be used anymore
+		var err error
+		if stream {
+			_, err = session.CallStream(context.Background(), &Get{})
+		} else {
+			err = session.Call(&Get{}, &RPCReplyData{})
+		}
+		var mismatch *MessageIDError
+		if !errors.As(err, &mismatch) || mismatch.Received != "99" {
+			t.Fatalf("Expected message-id mismatch, got %v", err)
+		} else if session.aborted() != err {
+			t.Fatalf("Session not failed: %v", session.aborted())
+		} else if err := session.Call(&Get{}, &RPCReplyData{}); err == nil {
+			t.Fatal("Failed session still usable")
+		}
+	}
+}
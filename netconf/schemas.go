@@ -0,0 +1,270 @@
+/**
+ * Copyright (c) 2019-2020 Cisco Systems
+ *
+ * Author: Steven Barth <stbarth@cisco.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package netconf
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Schema formats defined in ietf-netconf-monitoring
+const (
+	SchemaFormatYang SchemaFormat = "yang"
+	SchemaFormatYin  SchemaFormat = "yin"
+	SchemaFormatXSD  SchemaFormat = "xsd"
+	SchemaFormatRNG  SchemaFormat = "rng"
+	SchemaFormatRNC  SchemaFormat = "rnc"
+)
+
+// SchemaFormat is the format of a schema, the name of its identity without prefix
+type SchemaFormat string
+
+// UnmarshalXML schema format from a prefixed identityref
+func (f *SchemaFormat) UnmarshalXML(decoder *xml.Decoder, start xml.StartElement) error {
+	_, name, err := unmarshalIdentity(decoder, start, NsNetconfMonitoring)
+	*f = SchemaFormat(name)
+	return err
+}
+
+// MarshalXML schema format as prefixed identityref
+func (f SchemaFormat) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	return marshalIdentity(e, start, "ncm", NsNetconfMonitoring, string(f))
+}
+
+// Schema is a schema the server provides as listed in netconf-state/schemas (RFC 6022)
+type Schema struct {
+	Identifier string       `xml:"urn:ietf:params:xml:ns:yang:ietf-netconf-monitoring identifier"`
+	Version    string       `xml:"urn:ietf:params:xml:ns:yang:ietf-netconf-monitoring version"`
+	Format     SchemaFormat `xml:"urn:ietf:params:xml:ns:yang:ietf-netconf-monitoring format"`
+	Namespace  string       `xml:"urn:ietf:params:xml:ns:yang:ietf-netconf-monitoring namespace"`
+	Location   []string     `xml:"urn:ietf:params:xml:ns:yang:ietf-netconf-monitoring location"`
+}
+
+// FileName returns the conventional file name of the schema, i.e. module@revision.yang
+func (schema *Schema) FileName() string {
+	name := schema.Identifier
+	if len(schema.Version) > 0 {
+		name += "@" + schema.Version
+	}
+	return name + "." + string(schema.Format)
+}
+
+// ListSchemas retrieves the schemas the server provides from ietf-netconf-monitoring
+func (s *Session) ListSchemas(ctx context.Context) ([]Schema, error) {
+	state, err := s.getNetconfState(ctx, "<schemas/>")
+	if err != nil {
+		return nil, err
+	}
+	return state.Schemas, nil
+}
+
+// DownloadSchema retrieves the text of a schema using <get-schema>, the version and format are optional
+func (s *Session) DownloadSchema(ctx context.Context, identifier string, version string, format SchemaFormat) ([]byte, error) {
+	request := &GetSchema{Identifier: identifier}
+	if len(version) > 0 {
+		request.Version = &version
+	}
+	if len(format) > 0 {
+		formatName := string(format)
+		request.Format = &formatName
+	}
+
+	reply := &struct {
+		RPCReply
+		Data *struct {
+			Text     string `xml:",chardata"`
+			InnerXML []byte `xml:",innerxml"`
+		} `xml:"data"`
+	}{}
+	err := s.CallContext(ctx, request, reply)
+	if err == nil {
+		err = reply.Err()
+	}
+	if err != nil {
+		return nil, err
+	} else if reply.Data == nil {
+		return nil, &MalformedReplyError{Reason: "no data element"}
+	}
+
+	// YANG and RNC are sent as text, other formats as XML
+	if format == SchemaFormatYin || format == SchemaFormatXSD || format == SchemaFormatRNG {
+		return bytes.TrimSpace(reply.Data.InnerXML), nil
+	}
+	return []byte(reply.Data.Text), nil
+}
+
+// SchemaDownloadOptions defines optional parameters of DownloadSchemas
+type SchemaDownloadOptions struct {
+	Client  Client         // Opens additional sessions for parallel downloads, sequential download if nil
+	Workers int            // Number of sessions downloading in parallel including the given one, 4 if zero
+	Formats []SchemaFormat // Formats in order of preference, only the first available one is downloaded per schema. YANG and YIN by default.
+}
+
+// SchemaFile is a schema of the manifest with the path it was stored at
+type SchemaFile struct {
+	Schema
+	Path    string
+	Skipped bool  // The file already existed and was not downloaded
+	Err     error // Download or write failure
+}
+
+// SchemaManifest lists the schemas of a server downloaded to a directory
+type SchemaManifest struct {
+	Files []SchemaFile
+}
+
+// DownloadSchemas downloads all schemas listed in ietf-netconf-monitoring into a directory as
+// module@revision.yang files, skipping those already on disk. If any download fails, the manifest
+// is returned along with the first error and failed files are marked in the manifest.
+func DownloadSchemas(ctx context.Context, session *Session, dir string, options *SchemaDownloadOptions) (*SchemaManifest, error) {
+	opts := SchemaDownloadOptions{Workers: 4, Formats: []SchemaFormat{SchemaFormatYang, SchemaFormatYin}}
+	if options != nil {
+		opts.Client = options.Client
+		if options.Workers > 0 {
+			opts.Workers = options.Workers
+		}
+		if len(options.Formats) > 0 {
+			opts.Formats = options.Formats
+		}
+	}
+	if opts.Client == nil {
+		opts.Workers = 1
+	}
+
+	schemas, err := session.ListSchemas(ctx)
+	if err != nil {
+		return nil, err
+	} else if err = os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	manifest := &SchemaManifest{}
+	for _, schema := range selectSchemas(schemas, opts.Formats) {
+		file := SchemaFile{Schema: schema, Path: filepath.Join(dir, schema.FileName())}
+		if strings.ContainsAny(schema.Identifier+schema.Version, `/\`) || strings.HasPrefix(schema.Identifier, ".") {
+			file.Err = fmt.Errorf("Invalid schema identifier %q version %q", schema.Identifier, schema.Version)
+		} else if _, err := os.Stat(file.Path); err == nil {
+			file.Skipped = true
+		}
+		manifest.Files = append(manifest.Files, file)
+	}
+
+	// Download missing schemas, each worker using its own session
+	files := make(chan *SchemaFile)
+	var wait sync.WaitGroup
+	worker := func(session *Session) {
+		defer wait.Done()
+		for file := range files {
+			file.Err = downloadSchemaFile(ctx, session, file)
+		}
+	}
+
+	wait.Add(1)
+	go worker(session)
+	var workerErr error
+	for i := 1; i < opts.Workers; i++ {
+		workerSession, err := NewSessionContext(ctx, opts.Client)
+		if err != nil {
+			workerErr = err
+			break
+		}
+		defer workerSession.Close()
+		wait.Add(1)
+		go worker(workerSession)
+	}
+
+	for i := range manifest.Files {
+		if file := &manifest.Files[i]; !file.Skipped && file.Err == nil {
+			files <- file
+		}
+	}
+	close(files)
+	wait.Wait()
+
+	for _, file := range manifest.Files {
+		if file.Err != nil {
+			return manifest, file.Err
+		}
+	}
+	return manifest, workerErr
+}
+
+// selectSchemas returns the schemas in the first of the given formats available for each version of a module
+func selectSchemas(schemas []Schema, formats []SchemaFormat) []Schema {
+	type key struct {
+		identifier string
+		version    string
+	}
+	selected := make(map[key]int)
+	var result []Schema
+	for _, schema := range schemas {
+		preference := -1
+		for i, format := range formats {
+			if schema.Format == format {
+				preference = i
+			}
+		}
+		if preference < 0 {
+			continue
+		}
+
+		k := key{schema.Identifier, schema.Version}
+		if i, ok := selected[k]; !ok {
+			selected[k] = len(result)
+			result = append(result, schema)
+		} else {
+			for j, format := range formats {
+				if format == result[i].Format && j > preference {
+					result[i] = schema
+				}
+			}
+		}
+	}
+	return result
+}
+
+// downloadSchemaFile downloads a schema into a temporary file and renames it once complete
+func downloadSchemaFile(ctx context.Context, session *Session, file *SchemaFile) error {
+	data, err := session.DownloadSchema(ctx, file.Identifier, file.Version, file.Format)
+	if err != nil {
+		return err
+	}
+
+	temp, err := os.CreateTemp(filepath.Dir(file.Path), "."+filepath.Base(file.Path)+".*")
+	if err != nil {
+		return err
+	}
+	_, err = temp.Write(data)
+	if errClose := temp.Close(); err == nil {
+		err = errClose
+	}
+	if err == nil {
+		err = os.Rename(temp.Name(), file.Path)
+	}
+	if err != nil {
+		os.Remove(temp.Name())
+	}
+	return err
+}
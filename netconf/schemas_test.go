@@ -0,0 +1,128 @@
+/**
+ * Copyright (c) 2019-2020 Cisco Systems
+ *
+ * Author: Steven Barth <stbarth@cisco.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package netconf
+
+import (
+	"context"
+	"encoding/xml"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+const testSchemas = `<netconf-state xmlns="urn:ietf:params:xml:ns:yang:ietf-netconf-monitoring"><schemas>
+  <schema><identifier>example</identifier><version>2020-01-01</version><format>yin</format>
+    <namespace>urn:example</namespace><location>NETCONF</location></schema>
+  <schema><identifier>example</identifier><version>2020-01-01</version><format>ncm:yang</format>
+    <namespace>urn:example</namespace><location>NETCONF</location></schema>
+  <schema><identifier>example-types</identifier><version></version><format>yin</format>
+    <namespace>urn:example:types</namespace><location>NETCONF</location></schema>
+  <schema><identifier>example-xsd</identifier><version>1</version><format>xsd</format>
+    <namespace>urn:example:xsd</namespace><location>NETCONF</location></schema>
+  <schema><identifier>existing</identifier><version>2019-01-01</version><format>yang</format>
+    <namespace>urn:existing</namespace><location>NETCONF</location></schema>
+  <schema><identifier>../evil</identifier><version>2019-01-01</version><format>yang</format>
+    <namespace>urn:evil</namespace><location>NETCONF</location></schema>
+</schemas></netconf-state>`
+
+// schemaData is the reply content of <get-schema>
+type schemaData struct {
+	XMLName  xml.Name `xml:"urn:ietf:params:xml:ns:yang:ietf-netconf-monitoring data"`
+	InnerXML []byte   `xml:",innerxml"`
+}
+
+// testPipeClient creates sessions to a server over in-memory connections
+type testPipeClient struct {
+	server *Server
+}
+
+func (c *testPipeClient) NewSession() (*Session, error) {
+	return c.NewSessionContext(context.Background())
+}
+
+func (c *testPipeClient) NewSessionContext(ctx context.Context) (*Session, error) {
+	client, conn := net.Pipe()
+	go c.server.ServeTransport(conn)
+	return NewSessionTransport(ctx, client, SessionOptions{})
+}
+
+func (c *testPipeClient) Close() error {
+	return nil
+}
+
+func TestDownloadSchemas(t *testing.T) {
+	var mutex sync.Mutex
+	downloads := 0
+
+	server := NewServer(CapMonitoring + "?module=ietf-netconf-monitoring&revision=2010-10-04")
+	server.Handle(xml.Name{Space: NsNetconf, Local: "get"}, func(s *ServerSession, r *ServerRequest) (interface{}, error) {
+		return &DataContent{InnerXML: []byte(testSchemas)}, nil
+	})
+	server.Handle(xml.Name{Space: NsNetconfMonitoring, Local: "get-schema"}, func(s *ServerSession, r *ServerRequest) (interface{}, error) {
+		request := &GetSchema{}
+		if err := r.Decode(request); err != nil {
+			return nil, err
+		}
+		mutex.Lock()
+		downloads++
+		mutex.Unlock()
+
+		reply := &schemaData{InnerXML: []byte("<module xmlns=\"urn:ietf:params:xml:ns:yang:yin:1\" name=\"" + request.Identifier + "\"/>")}
+		if *request.Format == "yang" {
+			reply.InnerXML = []byte("module " + request.Identifier + " { description \"&lt;example&gt;\"; }")
+		}
+		return reply, nil
+	})
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "existing@2019-01-01.yang"), []byte("existing"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	client := &testPipeClient{server: server}
+	session := startPipeServer(t, server)
+	manifest, err := DownloadSchemas(context.Background(), session, dir, &SchemaDownloadOptions{Client: client, Workers: 2})
+	if err == nil || len(manifest.Files) != 4 || manifest.Files[3].Err != err {
+		t.Fatalf("Expected error for invalid identifier, got %v", err)
+	}
+
+	expected := map[string]string{
+		"example@2020-01-01.yang":  "module example { description \"<example>\"; }",
+		"example-types.yin":        "<module xmlns=\"urn:ietf:params:xml:ns:yang:yin:1\" name=\"example-types\"/>",
+		"existing@2019-01-01.yang": "existing",
+	}
+	for _, file := range manifest.Files[:3] {
+		data, err := os.ReadFile(file.Path)
+		if err != nil || file.Err != nil {
+			t.Fatalf("Unexpected error %v, %v", err, file.Err)
+		} else if string(data) != expected[filepath.Base(file.Path)] {
+			t.Fatalf("Unexpected %s: %q", file.Path, data)
+		} else if file.Skipped != (file.Identifier == "existing") {
+			t.Fatalf("Unexpected skip of %s", file.Path)
+		}
+	}
+
+	if entries, _ := os.ReadDir(dir); len(entries) != 3 {
+		t.Fatalf("Unexpected files %v", entries)
+	} else if downloads != 2 {
+		t.Fatalf("Expected 2 downloads, got %d", downloads)
+	}
+}
@@ -0,0 +1,488 @@
+/**
+ * Copyright (c) 2019-2020 Cisco Systems
+ *
+ * Author: Steven Barth <stbarth@cisco.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package netconf
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// ErrServerClosed is returned when serving on a closed server
+var ErrServerClosed = errors.New("NETCONF server closed")
+
+// ServerHandler processes an RPC received by a server. It returns the content of the reply or nil for <ok/>.
+// Errors are sent as rpc-errors: *RPCErrors, RPCError and ErrorTag values are sent as they are,
+// other errors as operation-failed.
+type ServerHandler func(session *ServerSession, request *ServerRequest) (interface{}, error)
+
+// ServerRequest is an RPC received by a server
+type ServerRequest struct {
+	MessageID string
+	Operation xml.Name   // Qualified name of the operation element
+	Attrs     []xml.Attr // Attributes of the <rpc> element
+
+	message []byte
+}
+
+// Decode the operation element, e.g. into one of the operation structs like GetConfig
+func (r *ServerRequest) Decode(v interface{}) error {
+	decoder := xml.NewDecoder(bytes.NewReader(r.message))
+	for depth := 0; ; {
+		token, err := decoder.Token()
+		if err != nil {
+			return err
+		}
+
+		switch element := token.(type) {
+		case xml.StartElement:
+			if depth == 1 {
+				return decoder.DecodeElement(v, &element)
+			}
+			depth++
+		case xml.EndElement:
+			depth--
+		}
+	}
+}
+
+// DataContent is the content of a reply with a <data> element
+type DataContent struct {
+	XMLName  xml.Name `xml:"urn:ietf:params:xml:ns:netconf:base:1.0 data"`
+	InnerXML []byte   `xml:",innerxml"`
+}
+
+// Server is an in-process NETCONF server dispatching RPCs to handlers by the qualified name of the operation
+type Server struct {
+	Capabilities []string // Announced in addition to base:1.0 and base:1.1
+	Limits       Limits   // Limits for messages received from clients
+
+	mutex     sync.Mutex
+	handlers  map[xml.Name]ServerHandler
+	sessions  map[uint64]*ServerSession
+	listeners map[net.Listener]bool
+	lastID    uint64
+	closed    bool
+}
+
+// NewServer creates a server handling <close-session> and <kill-session>
+func NewServer(capabilities ...string) *Server {
+	server := &Server{
+		Capabilities: capabilities,
+		handlers:     make(map[xml.Name]ServerHandler),
+		sessions:     make(map[uint64]*ServerSession),
+		listeners:    make(map[net.Listener]bool),
+	}
+	server.Handle(xml.Name{Space: NsNetconf, Local: "close-session"}, handleCloseSession)
+	server.Handle(xml.Name{Space: NsNetconf, Local: "kill-session"}, handleKillSession)
+	return server
+}
+
+// Handle registers the handler for an operation replacing any previous one.
+// The handler for the zero name is called for operations without a handler of their own.
+func (s *Server) Handle(operation xml.Name, handler ServerHandler) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.handlers[operation] = handler
+}
+
+func (s *Server) handler(operation xml.Name) ServerHandler {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if handler, ok := s.handlers[operation]; ok {
+		return handler
+	}
+	return s.handlers[xml.Name{}]
+}
+
+// Session returns the session with the given ID or nil
+func (s *Server) Session(id uint64) *ServerSession {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.sessions[id]
+}
+
+// ServeSSH accepts SSH connections and serves NETCONF on netconf subsystem requests until the listener fails
+func (s *Server) ServeSSH(listener net.Listener, config *ssh.ServerConfig) error {
+	s.mutex.Lock()
+	if s.closed {
+		s.mutex.Unlock()
+		return ErrServerClosed
+	}
+	s.listeners[listener] = true
+	s.mutex.Unlock()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			s.mutex.Lock()
+			defer s.mutex.Unlock()
+			delete(s.listeners, listener)
+			if s.closed {
+				err = ErrServerClosed
+			}
+			return err
+		}
+		go s.serveSSHConn(conn, config)
+	}
+}
+
+func (s *Server) serveSSHConn(conn net.Conn, config *ssh.ServerConfig) {
+	sshConn, channels, requests, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	defer sshConn.Close()
+	go ssh.DiscardRequests(requests)
+
+	for newChannel := range channels {
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, "unknown channel type")
+			continue
+		}
+
+		channel, requests, err := newChannel.Accept()
+		if err == nil {
+			go s.serveSSHChannel(channel, requests)
+		}
+	}
+}
+
+func (s *Server) serveSSHChannel(channel ssh.Channel, requests <-chan *ssh.Request) {
+	started := false
+	for request := range requests {
+		subsystem := struct{ Name string }{}
+		if request.Type == "subsystem" && !started &&
+			ssh.Unmarshal(request.Payload, &subsystem) == nil && subsystem.Name == "netconf" {
+			started = true
+			request.Reply(true, nil)
+			go s.ServeTransport(channel)
+		} else {
+			request.Reply(false, nil)
+		}
+	}
+
+	if !started {
+		channel.Close()
+	}
+}
+
+// ServeTransport serves a NETCONF session on the given transport until it ends and closes the transport
+func (s *Server) ServeTransport(transport io.ReadWriteCloser) error {
+	session, err := s.newSession(transport)
+	if err == nil {
+		err = session.serve()
+		s.mutex.Lock()
+		delete(s.sessions, session.ID)
+		s.mutex.Unlock()
+	}
+
+	transport.Close()
+	return err
+}
+
+// Close stops all listeners and closes all sessions
+func (s *Server) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.closed = true
+
+	var err error
+	for listener := range s.listeners {
+		if errListener := listener.Close(); err == nil {
+			err = errListener
+		}
+	}
+	for _, session := range s.sessions {
+		session.Close()
+	}
+	return err
+}
+
+// ServerSession is a NETCONF session of a server
+type ServerSession struct {
+	ID           uint64
+	Capabilities map[string]string // Capabilities announced by the client
+
+	server  *Server
+	conn    *Session
+	closing bool
+}
+
+// newSession allocates a session ID, registers the session and exchanges hello messages
+func (s *Server) newSession(transport io.ReadWriteCloser) (*ServerSession, error) {
+	s.mutex.Lock()
+	if s.closed {
+		s.mutex.Unlock()
+		return nil, ErrServerClosed
+	}
+
+	s.lastID++
+	session := &ServerSession{ID: s.lastID, server: s, conn: &Session{
+		transport:   transport,
+		reader:      newMessageReader(transport),
+		newFramer:   newFramerV10,
+		newUnframer: newUnframerV10,
+		options:     SessionOptions{Limits: s.Limits},
+	}}
+	s.sessions[session.ID] = session
+	capabilities := append([]string{CapNetconf10, CapNetconf11}, s.Capabilities...)
+	s.mutex.Unlock()
+
+	hello, err := session.conn.hello(&helloMessage{SessionID: session.ID, Capabilities: capabilities})
+	if err == nil && hello.SessionID != 0 {
+		err = ErrCapabilitiesExchange
+	}
+	if err != nil {
+		s.mutex.Lock()
+		delete(s.sessions, session.ID)
+		s.mutex.Unlock()
+		return nil, err
+	}
+
+	session.Capabilities = session.conn.Capabilities
+	return session, nil
+}
+
+// HasCapability returns true if the client announced any of the given capabilities
+func (s *ServerSession) HasCapability(capabilities ...string) bool {
+	return s.conn.HasCapability(capabilities...)
+}
+
+// Close terminates the session, e.g. as a result of <kill-session>
+func (s *ServerSession) Close() error {
+	s.conn.abort()
+	return nil
+}
+
+// Notify sends a notification with the given event content to the client
+func (s *ServerSession) Notify(eventTime time.Time, event interface{}) error {
+	return s.send(&struct {
+		XMLName   xml.Name `xml:"urn:ietf:params:xml:ns:netconf:notification:1.0 notification"`
+		EventTime string   `xml:"eventTime"`
+		Event     interface{}
+	}{EventTime: eventTime.Format(time.RFC3339Nano), Event: event})
+}
+
+// send writes a message to the client
+func (s *ServerSession) send(message interface{}) error {
+	s.conn.writeMutex.Lock()
+	defer s.conn.writeMutex.Unlock()
+
+	writer := s.conn.NewWriter()
+	err := xml.NewEncoder(writer).Encode(message)
+	if err == nil {
+		err = writer.Close()
+	}
+	return err
+}
+
+// serve processes RPCs until the session is closed
+func (s *ServerSession) serve() error {
+	for !s.closing {
+		reader := s.conn.NewReader()
+		message, err := io.ReadAll(reader)
+		if err != nil {
+			if s.conn.aborted() != nil || (len(message) == 0 && s.conn.reader.err == io.EOF) {
+				return nil // Killed or closed by the client
+			}
+			return err
+		}
+
+		if err = s.handle(message); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// handle dispatches an RPC and sends the reply
+func (s *ServerSession) handle(message []byte) error {
+	request := &ServerRequest{message: message}
+	decoder := xml.NewDecoder(bytes.NewReader(message))
+
+	// Find the <rpc> and operation elements
+	var rpc, operation *xml.StartElement
+	for operation == nil {
+		token, err := decoder.Token()
+		if err != nil {
+			return s.reply(request, nil, RPCError{ErrorType: "rpc", ErrorTag: string(TagMalformedMessage)})
+		}
+
+		if element, ok := token.(xml.StartElement); ok && rpc == nil {
+			rpc = &element
+		} else if ok {
+			operation = &element
+		} else if _, ok := token.(xml.EndElement); ok {
+			break
+		}
+	}
+
+	if rpc.Name.Space != NsNetconf || rpc.Name.Local != "rpc" {
+		return s.reply(request, nil, RPCError{ErrorType: "rpc", ErrorTag: string(TagMalformedMessage)})
+	}
+
+	for _, attr := range rpc.Attr {
+		if attr.Name.Space != "xmlns" && !(attr.Name.Space == "" && attr.Name.Local == "xmlns") {
+			request.Attrs = append(request.Attrs, attr)
+		}
+	}
+
+	var rpcError RPCError
+	var handler ServerHandler
+	if request.MessageID = attrValue(*rpc, "message-id"); request.MessageID == "" {
+		rpcError = RPCError{ErrorType: "rpc", ErrorTag: string(TagMissingAttribute)}
+		rpcError.ErrorInfo.BadAttribute, rpcError.ErrorInfo.BadElement = "message-id", "rpc"
+	} else if operation == nil {
+		rpcError = RPCError{ErrorType: "rpc", ErrorTag: string(TagMissingElement)}
+		rpcError.ErrorInfo.BadElement = "rpc"
+	} else if handler = s.server.handler(operation.Name); handler == nil {
+		rpcError = RPCError{ErrorType: "protocol", ErrorTag: string(TagOperationNotSupported)}
+	} else {
+		request.Operation = operation.Name
+		content, err := handler(s, request)
+		return s.reply(request, content, err)
+	}
+	return s.reply(request, nil, rpcError)
+}
+
+// rpcErrorElement is the encoding of an rpc-error omitting empty elements
+type rpcErrorElement struct {
+	XMLName       xml.Name          `xml:"rpc-error"`
+	ErrorType     string            `xml:"error-type"`
+	ErrorTag      string            `xml:"error-tag"`
+	ErrorSeverity string            `xml:"error-severity"`
+	ErrorAppTag   string            `xml:"error-app-tag,omitempty"`
+	ErrorPath     string            `xml:"error-path,omitempty"`
+	ErrorMessage  string            `xml:"error-message,omitempty"`
+	ErrorInfo     *rpcErrorInfoElem `xml:"error-info"`
+}
+
+type rpcErrorInfoElem struct {
+	BadElement   string `xml:"bad-element,omitempty"`
+	BadAttribute string `xml:"bad-attribute,omitempty"`
+	BadNamespace string `xml:"bad-namespace,omitempty"`
+	SessionID    string `xml:"session-id,omitempty"`
+	InnerXML     []byte `xml:",innerxml"`
+}
+
+// rpcErrorsOf converts a handler error to rpc-errors
+func rpcErrorsOf(err error) []RPCError {
+	var rpcErrors *RPCErrors
+	var rpcErrorPtr *RPCError
+	var rpcError RPCError
+	var tag ErrorTag
+
+	switch {
+	case errors.As(err, &rpcErrors):
+		return append(append([]RPCError{}, rpcErrors.Errors...), rpcErrors.Warnings...)
+	case errors.As(err, &rpcErrorPtr):
+		return []RPCError{*rpcErrorPtr}
+	case errors.As(err, &rpcError):
+		return []RPCError{rpcError}
+	case errors.As(err, &tag):
+		return []RPCError{{ErrorTag: string(tag)}}
+	}
+	return []RPCError{{ErrorTag: string(TagOperationFailed), ErrorMessage: err.Error()}}
+}
+
+// newRPCErrorElement fills in defaults for missing mandatory elements of an rpc-error
+func newRPCErrorElement(e RPCError) rpcErrorElement {
+	element := rpcErrorElement{
+		ErrorType:     e.ErrorType,
+		ErrorTag:      e.ErrorTag,
+		ErrorSeverity: e.ErrorSeverity,
+		ErrorAppTag:   e.ErrorAppTag,
+		ErrorPath:     e.ErrorPath,
+		ErrorMessage:  e.ErrorMessage,
+	}
+	if element.ErrorType == "" {
+		element.ErrorType = "application"
+	}
+	if element.ErrorTag == "" {
+		element.ErrorTag = string(TagOperationFailed)
+	}
+	if element.ErrorSeverity == "" {
+		element.ErrorSeverity = SeverityError
+	}
+
+	// Raw error-info replaces the individual elements as it contains them if the error was received
+	info := e.ErrorInfo
+	if len(bytes.TrimSpace(info.InnerXML)) > 0 {
+		element.ErrorInfo = &rpcErrorInfoElem{InnerXML: info.InnerXML}
+	} else if info.BadElement != "" || info.BadAttribute != "" || info.BadNamespace != "" || info.SessionID != "" {
+		element.ErrorInfo = &rpcErrorInfoElem{BadElement: info.BadElement, BadAttribute: info.BadAttribute,
+			BadNamespace: info.BadNamespace, SessionID: info.SessionID}
+	}
+	return element
+}
+
+// reply sends an rpc-reply with the given content or rpc-errors
+func (s *ServerSession) reply(request *ServerRequest, content interface{}, err error) error {
+	reply := &struct {
+		XMLName xml.Name   `xml:"urn:ietf:params:xml:ns:netconf:base:1.0 rpc-reply"`
+		Attrs   []xml.Attr `xml:",any,attr"`
+		Errors  []rpcErrorElement
+		OK      *struct{} `xml:"ok"`
+		Content interface{}
+	}{Attrs: request.Attrs}
+
+	failed := false
+	if err != nil {
+		for _, rpcError := range rpcErrorsOf(err) {
+			element := newRPCErrorElement(rpcError)
+			failed = failed || element.ErrorSeverity != SeverityWarning
+			reply.Errors = append(reply.Errors, element)
+		}
+	}
+
+	if !failed && content == nil {
+		reply.OK = &struct{}{}
+	} else if !failed {
+		reply.Content = content
+	}
+	return s.send(reply)
+}
+
+func handleCloseSession(session *ServerSession, request *ServerRequest) (interface{}, error) {
+	session.closing = true
+	return nil, nil
+}
+
+func handleKillSession(session *ServerSession, request *ServerRequest) (interface{}, error) {
+	kill := &KillSession{}
+	if err := request.Decode(kill); err != nil {
+		return nil, RPCError{ErrorType: "protocol", ErrorTag: string(TagMalformedMessage), ErrorMessage: err.Error()}
+	}
+
+	target := session.server.Session(kill.SessionID)
+	if target == nil || target == session {
+		return nil, RPCError{ErrorType: "protocol", ErrorTag: string(TagInvalidValue),
+			ErrorMessage: "Invalid session-id " + strconv.FormatUint(kill.SessionID, 10)}
+	}
+	return nil, target.Close()
+}
@@ -0,0 +1,134 @@
+/**
+ * Copyright (c) 2019-2020 Cisco Systems
+ *
+ * Author: Steven Barth <stbarth@cisco.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package netconf
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/xml"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// startTestServer serves NETCONF over SSH on a random local port and returns the address
+func startTestServer(t *testing.T, server *Server) string {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := &ssh.ServerConfig{NoClientAuth: true}
+	config.AddHostKey(signer)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- server.ServeSSH(listener, config)
+	}()
+	t.Cleanup(func() {
+		server.Close()
+		if err := <-done; err != ErrServerClosed {
+			t.Error(err)
+		}
+	})
+	return listener.Addr().String()
+}
+
+func TestServerSSH(t *testing.T) {
+	type event struct {
+		XMLName xml.Name `xml:"urn:example event"`
+	}
+
+	server := NewServer(CapCandidate)
+	server.Handle(xml.Name{Space: NsNetconf, Local: "get-config"}, func(s *ServerSession, r *ServerRequest) (interface{}, error) {
+		request := &GetConfig{}
+		if err := r.Decode(request); err != nil {
+			return nil, err
+		}
+		if err := s.Notify(time.Now(), &event{}); err != nil {
+			return nil, err
+		}
+		return &DataContent{InnerXML: []byte(`<top xmlns="urn:example">` + string(request.Source) + `</top>`)}, nil
+	})
+	server.Handle(xml.Name{Space: NsNetconf, Local: "lock"}, func(s *ServerSession, r *ServerRequest) (interface{}, error) {
+		return nil, TagLockDenied
+	})
+
+	client, err := DialSSHWithPassword(startTestServer(t, server), "user", "", ssh.InsecureIgnoreHostKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	session, err := client.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	} else if session.SessionID != 1 || !session.HasCapability(CapCandidate) {
+		t.Fatalf("Unexpected hello: session-id %d, capabilities %v", session.SessionID, session.Capabilities)
+	}
+
+	// Replies must echo all attributes of the request
+	session.StrictReplies = true
+	session.RPCAttributes = []xml.Attr{{Name: xml.Name{Space: "urn:example", Local: "tag"}, Value: "value"}}
+
+	reply := &RPCReplyData{}
+	if err := session.Call(&GetConfig{Source: Running}, reply); err != nil {
+		t.Fatal(err)
+	} else if reply.Kind() != ReplyData || string(reply.Data.InnerXML) != `<top xmlns="urn:example">running</top>` {
+		t.Fatalf("Unexpected reply %q", reply.Data.InnerXML)
+	}
+
+	notification := &struct {
+		Event *event
+	}{}
+	if err := session.Receive(notification); err != nil || notification.Event == nil {
+		t.Fatalf("Notification not received: %v", err)
+	}
+
+	if err := session.CallSimple(&Lock{Target: Running}); !errors.Is(err, TagLockDenied) {
+		t.Fatalf("Expected lock-denied, got %v", err)
+	} else if err := session.CallSimple(&Validate{}); !errors.Is(err, TagOperationNotSupported) {
+		t.Fatalf("Expected operation-not-supported, got %v", err)
+	}
+
+	// Kill the first session from a second one
+	other, err := client.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	} else if err := other.CallSimple(&KillSession{SessionID: other.SessionID}); !errors.Is(err, TagInvalidValue) {
+		t.Fatalf("Expected invalid-value, got %v", err)
+	} else if err := other.CallSimple(&KillSession{SessionID: session.SessionID}); err != nil {
+		t.Fatal(err)
+	} else if err := session.CallSimple(&Lock{Target: Running}); err == nil {
+		t.Fatal("Killed session still usable")
+	} else if err := other.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
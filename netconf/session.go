@@ -19,117 +19,381 @@
 package netconf
 
 import (
+	"bytes"
+	"context"
 	"encoding/xml"
 	"errors"
+	"fmt"
 	"io"
 	"strconv"
 	"strings"
+	"sync"
 )
 
 // ErrCapabilitiesExchange indicates a failed NETCONF hello-exchange due to incompatible versions or invalid session ID
 var ErrCapabilitiesExchange = errors.New("Capabilities exchange failed")
 
+// ErrSessionAborted indicates that the session transport was closed due to a cancelled operation
+var ErrSessionAborted = errors.New("NETCONF session aborted")
+
+// ErrSessionOptionsUnsupported indicates that a client does not support creating sessions with options
+var ErrSessionOptionsUnsupported = errors.New("Client does not support session options")
+
+// MessageIDError indicates an rpc-reply whose message-id does not match any outstanding request.
+// Sessions in sequential mode fail with it as the expected reply is still pending.
+type MessageIDError struct {
+	Expected string // empty if the reply was unsolicited
+	Received string
+}
+
+func (e *MessageIDError) Error() string {
+	if len(e.Expected) == 0 {
+		return fmt.Sprintf("Unsolicited NETCONF rpc-reply with message-id %q", e.Received)
+	}
+	return fmt.Sprintf("NETCONF rpc-reply message-id mismatch: expected %q, received %q", e.Expected, e.Received)
+}
+
 // Client defines a transport-independent interface for NETCONF clients
 type Client interface {
 	io.Closer
 	NewSession() (*Session, error)
 }
 
+// ContextClient is implemented by clients which can abort creating a session when a context ends
+type ContextClient interface {
+	Client
+	NewSessionContext(ctx context.Context) (*Session, error)
+}
+
+// NewSessionContext creates a new session of a client, aborting if the context ends first.
+// For clients not implementing ContextClient a session completed after the context ended is closed.
+func NewSessionContext(ctx context.Context, client Client) (*Session, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	} else if contextClient, ok := client.(ContextClient); ok {
+		return contextClient.NewSessionContext(ctx)
+	}
+
+	type result struct {
+		session *Session
+		err     error
+	}
+	created := make(chan result, 1)
+	go func() {
+		session, err := client.NewSession()
+		created <- result{session, err}
+	}()
+
+	select {
+	case result := <-created:
+		return result.session, result.err
+	case <-ctx.Done():
+		go func() {
+			if result := <-created; result.session != nil {
+				result.session.Close()
+			}
+		}()
+		return nil, ctx.Err()
+	}
+}
+
 // Session represents a session towards the server
 type Session struct {
 	SessionID    uint64
-	Capabilities map[string]string
+	Capabilities map[string]string  // Announced capabilities without query and their query
+	Modules      map[string]*Module // YANG modules announced as capabilities by name
 
+	// RPCAttributes are sent as additional attributes of every <rpc> element
+	RPCAttributes []xml.Attr
+	// StrictReplies requires replies to echo all attributes of the request
+	StrictReplies bool
+
+	options     SessionOptions
 	transport   io.ReadWriteCloser
-	newFramer   func(io.Writer) io.WriteCloser
-	newUnframer func(io.Reader) io.ReadCloser
+	reader      *messageReader
+	newFramer   func(io.Writer, uint32) io.WriteCloser
+	newUnframer func(*messageReader, uint32) io.ReadCloser
 	messageID   int
+	writeMutex  sync.Mutex
+	mux         *multiplexer
+
+	notificationMutex    sync.Mutex
+	notificationHandlers []*notificationHandlerEntry
+	notificationBacklog  [][]byte
+	subscriptions        map[uint32]*Subscription // Established subscriptions by ID
+
+	abortMutex sync.Mutex
+	abortErr   error
+
+	yangLibraries YangLibraryCache
+}
+
+// SessionOptions configure a session before its hello exchange, zero values select the defaults
+type SessionOptions struct {
+	ChunkSize uint32 // Maximum size of chunks sent with NETCONF 1.1 framing, DefaultChunkSize if zero
+	Limits    Limits // Limits for received messages including the hello, the session is closed if they are exceeded
+}
+
+// NewSessionTransport creates a session on an established transport, e.g. a custom or in-memory connection
+func NewSessionTransport(ctx context.Context, transport io.ReadWriteCloser, options SessionOptions) (*Session, error) {
+	return newSession(ctx, transport, options)
+}
+
+// optionsClient creates all sessions of a client with the same options
+type optionsClient struct {
+	Client
+	wrapping wrappingClient
+	options  SessionOptions
+}
+
+// ClientWithOptions returns a client creating all its sessions with the given options
+func ClientWithOptions(client Client, options SessionOptions) (Client, error) {
+	if callHome, ok := client.(*CallHomeClient); ok {
+		client = callHome.Client
+	}
+
+	wrapping, ok := client.(wrappingClient)
+	if !ok {
+		return nil, ErrSessionOptionsUnsupported
+	}
+	return &optionsClient{Client: client, wrapping: wrapping, options: options}, nil
+}
+
+// NewSession creates a new session with the options of the client
+func (c *optionsClient) NewSession() (*Session, error) {
+	return c.NewSessionContext(context.Background())
+}
+
+// NewSessionContext creates a new session with the options of the client, aborting if the context ends first
+func (c *optionsClient) NewSessionContext(ctx context.Context) (*Session, error) {
+	return c.newSessionWrapped(ctx, nil, SessionOptions{})
+}
+
+// newSessionWrapped prefers options of an outer client over its own
+func (c *optionsClient) newSessionWrapped(ctx context.Context, wrap transportWrapper, options SessionOptions) (*Session, error) {
+	if options == (SessionOptions{}) {
+		options = c.options
+	}
+	return c.wrapping.newSessionWrapped(ctx, wrap, options)
 }
 
-func newSession(transport io.ReadWriteCloser) (*Session, error) {
+func newSession(ctx context.Context, transport io.ReadWriteCloser, options SessionOptions) (*Session, error) {
 	session := Session{
+		options:     options,
 		transport:   transport,
+		reader:      newMessageReader(transport),
 		newFramer:   newFramerV10,
 		newUnframer: newUnframerV10,
 	}
+	if err := session.withContext(ctx, session.exchangeHello); err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
 
-	// Exchange capabilities
-	hello := &struct {
-		XMLName      xml.Name `xml:"urn:ietf:params:xml:ns:netconf:base:1.0 hello"`
-		SessionID    uint64   `xml:"session-id,omitempty"`
-		Capabilities []string `xml:"capabilities>capability"`
-	}{Capabilities: []string{CapNetconf10, CapNetconf11}}
+// withContext runs a blocking operation and aborts the session if the context ends before it completes
+func (s *Session) withContext(ctx context.Context, operation func() error) error {
+	if err := s.aborted(); err != nil {
+		return err
+	} else if err := ctx.Err(); err != nil {
+		return err
+	} else if ctx.Done() == nil {
+		return operation()
+	}
 
-	// Send hello message
-	framer := session.newFramer(transport)
-	if err := xml.NewEncoder(framer).Encode(hello); err != nil {
-		return nil, err
+	// The session is only aborted if the operation has not completed yet,
+	// an operation aborted this way always returns the error of the context.
+	var mutex sync.Mutex
+	var completed, aborted bool
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			mutex.Lock()
+			if !completed {
+				aborted = true
+				s.abort()
+			}
+			mutex.Unlock()
+		case <-done:
+		}
+	}()
+
+	err := operation()
+	mutex.Lock()
+	completed = true
+	if aborted {
+		err = ctx.Err()
 	}
-	if err := framer.Close(); err != nil {
-		return nil, err
+	mutex.Unlock()
+	close(done)
+	return err
+}
+
+// abort poisons the session and unblocks pending operations by closing the transport
+func (s *Session) abort() {
+	s.fail(ErrSessionAborted)
+}
+
+// fail poisons the session with the given error and closes the transport
+func (s *Session) fail(err error) {
+	s.abortMutex.Lock()
+	defer s.abortMutex.Unlock()
+	if s.abortErr == nil {
+		s.abortErr = err
+		s.transport.Close()
 	}
+}
 
-	// Retrieve and parse server's hello message
-	reader := session.NewReader()
-	hello.Capabilities = hello.Capabilities[:0]
-	if err := xml.NewDecoder(reader).Decode(&hello); err != nil {
-		return nil, err
-	} else if err := reader.Close(); err != nil {
-		return nil, err
+func (s *Session) aborted() error {
+	s.abortMutex.Lock()
+	defer s.abortMutex.Unlock()
+	return s.abortErr
+}
+
+// helloMessage models a NETCONF <hello> element
+type helloMessage struct {
+	XMLName      xml.Name `xml:"urn:ietf:params:xml:ns:netconf:base:1.0 hello"`
+	SessionID    uint64   `xml:"session-id,omitempty"`
+	Capabilities []string `xml:"capabilities>capability"`
+}
+
+// exchangeHello sends our hello message, parses the server's and selects the framing
+func (s *Session) exchangeHello() error {
+	hello, err := s.hello(&helloMessage{Capabilities: []string{CapNetconf10, CapNetconf11}})
+	if err != nil {
+		return err
 	}
 
 	// Check for non-0 session ID
-	if session.SessionID = hello.SessionID; session.SessionID == 0 {
-		return nil, ErrCapabilitiesExchange
+	if s.SessionID = hello.SessionID; s.SessionID == 0 {
+		return ErrCapabilitiesExchange
+	}
+	return nil
+}
+
+// hello sends our hello message, retrieves the peer's, parses its capabilities and selects the framing
+func (s *Session) hello(hello *helloMessage) (*helloMessage, error) {
+	// Send hello message while retrieving the peer's as both sides send theirs right away
+	framer := s.NewWriter()
+	sent := make(chan error, 1)
+	go func() {
+		err := xml.NewEncoder(framer).Encode(hello)
+		if err == nil {
+			err = framer.Close()
+		}
+		sent <- err
+	}()
+
+	// Retrieve and parse peer's hello message
+	reader := s.NewReader()
+	peer := &helloMessage{}
+	if err := xml.NewDecoder(reader).Decode(peer); err != nil {
+		return nil, err
+	} else if err := reader.Close(); err != nil {
+		return nil, err
+	} else if err := <-sent; err != nil {
+		return nil, err
 	}
 
 	// Parse capabilities
-	session.Capabilities = make(map[string]string)
-	for _, capability := range hello.Capabilities {
+	s.Capabilities = make(map[string]string)
+	for _, capability := range peer.Capabilities {
 		cap := strings.SplitN(capability, "?", 2)
 		if len(cap) > 1 {
-			session.Capabilities[cap[0]] = cap[1]
+			s.Capabilities[cap[0]] = cap[1]
 		} else {
-			session.Capabilities[cap[0]] = ""
+			s.Capabilities[cap[0]] = ""
 		}
 	}
+	s.Modules = parseModules(s.Capabilities)
 
 	// Check for compatible version and switch framing method if necessary
-	if _, compatible := session.Capabilities[CapNetconf11]; compatible {
-		session.newFramer = newFramerV11
-		session.newUnframer = newUnframerV11
-	} else if _, compatible := session.Capabilities[CapNetconf10]; !compatible {
+	if _, compatible := s.Capabilities[CapNetconf11]; compatible {
+		s.newFramer = newFramerV11
+		s.newUnframer = newUnframerV11
+	} else if _, compatible := s.Capabilities[CapNetconf10]; !compatible {
 		return nil, ErrCapabilitiesExchange
 	}
 
-	return &session, nil
+	return peer, nil
+}
+
+// HasCapability returns true if the server announced any of the given capabilities
+func (s *Session) HasCapability(capabilities ...string) bool {
+	for _, capability := range capabilities {
+		if _, ok := s.Capabilities[capability]; ok {
+			return true
+		}
+	}
+	return false
 }
 
 // Call a NETCONF RPC and retrieve its reply
 func (s *Session) Call(request interface{}, response interface{}) error {
-	var err error
+	return s.CallContext(context.Background(), request, response)
+}
+
+// CallContext calls a NETCONF RPC and retrieves its reply.
+// If the context ends before the reply is received the session is aborted and unusable afterwards.
+// In multiplexed mode the session stays usable and a late reply is discarded instead.
+// A nil response sends the request without waiting for its reply, in multiplexed mode the reply is discarded.
+func (s *Session) CallContext(ctx context.Context, request interface{}, response interface{}) error {
+	if s.mux != nil {
+		return s.mux.call(ctx, request, response)
+	}
+	return s.withContext(ctx, func() error {
+		return s.call(request, response)
+	})
+}
+
+// writeRPC allocates a message-id and sends the request, register is called with the ID before sending
+func (s *Session) writeRPC(request interface{}, register func(string)) (string, error) {
+	s.writeMutex.Lock()
+	defer s.writeMutex.Unlock()
+
 	s.messageID++
+	messageID := strconv.Itoa(s.messageID)
+	if register != nil {
+		register(messageID)
+	}
+
 	writer := s.NewWriter()
 	element := xml.StartElement{
 		Name: xml.Name{Local: "rpc", Space: "urn:ietf:params:xml:ns:netconf:base:1.0"},
-		Attr: []xml.Attr{{Name: xml.Name{Local: "message-id"}, Value: strconv.Itoa(s.messageID)}},
+		Attr: s.rpcAttributes(messageID),
 	}
 	rpc := &struct{ Operation interface{} }{Operation: request}
-	if err = xml.NewEncoder(writer).EncodeElement(rpc, element); err == nil {
+	err := xml.NewEncoder(writer).EncodeElement(rpc, element)
+	if err == nil {
 		err = writer.Close()
 	}
+	return messageID, err
+}
+
+func (s *Session) call(request interface{}, response interface{}) error {
+	messageID, err := s.writeRPC(request, nil)
 
-	// Read until rpc-reply (skip spurious notifications etc.)
+	// Read until rpc-reply (pass on notifications, skip other spurious messages)
 	for haveReply := false; !haveReply && err == nil && response != nil; {
-		reader := s.NewReader()
-		decoder := xml.NewDecoder(reader)
+		reader := s.newReader()
+		capture := &captureBuffer{}
+		decoder := xml.NewDecoder(io.TeeReader(reader, capture))
 
 		for err == nil {
 			var token xml.Token
 			token, err = decoder.Token() // Read until the XML document root
 			if element, ok := token.(xml.StartElement); ok {
+				if element.Name.Local == "notification" {
+					if _, err = io.Copy(capture, reader); err == nil {
+						s.handleNotification(capture.Bytes())
+					}
+					break
+				}
+
+				capture.disabled = true
 				if element.Name.Local == "rpc-reply" {
-					err = decoder.DecodeElement(response, &element)
+					err = s.decodeReply(decoder, element, messageID, response, &reader.scanner)
 					haveReply = true
 				}
 				break
@@ -141,43 +405,125 @@ func (s *Session) Call(request interface{}, response interface{}) error {
 	return err
 }
 
+// attrValue returns the value of an unqualified attribute of an element or an empty string
+func attrValue(element xml.StartElement, name string) string {
+	for _, attr := range element.Attr {
+		if attr.Name.Local == name && len(attr.Name.Space) == 0 {
+			return attr.Value
+		}
+	}
+	return ""
+}
+
 // NewReader creates a low-level reader for receiving the next NETCONF message
 func (s *Session) NewReader() io.ReadCloser {
-	return s.newUnframer(s.transport)
+	return s.newReader()
+}
+
+func (s *Session) newReader() *messageScanner {
+	reader := s.newUnframer(s.reader, s.options.Limits.MaxChunkSize)
+	return &messageScanner{session: s, reader: reader, limits: s.options.Limits}
 }
 
 // NewWriter creates a low-level writing for sending the next NETCONF message
 func (s *Session) NewWriter() io.WriteCloser {
-	return s.newFramer(s.transport)
+	return s.newFramer(s.transport, s.options.ChunkSize)
 }
 
-// Receive a message from the server, e.g. a notification
+// Receive a message from the server, e.g. a notification.
+// Notifications received during Call without registered handlers are returned first.
 func (s *Session) Receive(response interface{}) error {
-	reader := s.NewReader()
-	err := xml.NewDecoder(reader).Decode(response)
-	errReader := reader.Close()
-	if err == nil {
-		err = errReader
+	return s.ReceiveContext(context.Background(), response)
+}
+
+// ReceiveContext receives a message from the server, aborting the session if the context ends first.
+// In multiplexed mode only messages other than correlated rpc-replies are received and the session stays usable.
+func (s *Session) ReceiveContext(ctx context.Context, response interface{}) error {
+	if s.mux != nil {
+		return s.mux.receive(ctx, response)
 	}
-	return err
+	return s.withContext(ctx, func() error {
+		return s.receive(response)
+	})
+}
+
+// receive returns retained notifications first and passes further notifications to handlers like call
+func (s *Session) receive(response interface{}) error {
+	for len(s.notificationBacklog) == 0 {
+		data, root, _, err := s.readMessage()
+		if err != nil {
+			return err
+		} else if root.Name.Local != "notification" || !s.dispatchNotification(data) {
+			return xml.Unmarshal(data, response)
+		}
+	}
+
+	data := s.notificationBacklog[0]
+	s.notificationBacklog = s.notificationBacklog[1:]
+	return xml.Unmarshal(data, response)
 }
 
-// CallSimple calls a NETCONF RPC and returns the first rpc-error or nil if there was none
+// readMessage reads the next complete message and returns it together with its root element
+// and the scanner which classified it while it was read
+func (s *Session) readMessage() ([]byte, xml.StartElement, *replyScanner, error) {
+	for {
+		reader := s.newReader()
+		data, err := io.ReadAll(reader)
+		if errReader := reader.Close(); err == nil {
+			err = errReader
+		}
+		if err != nil {
+			return nil, xml.StartElement{}, nil, err
+		}
+
+		decoder := xml.NewDecoder(bytes.NewReader(data))
+		for {
+			token, err := decoder.Token()
+			if err != nil {
+				break // Skip empty or malformed messages
+			} else if root, ok := token.(xml.StartElement); ok {
+				return data, root, &reader.scanner, nil
+			}
+		}
+	}
+}
+
+// CallSimple calls a NETCONF RPC and returns an error for rpc-errors of severity error or nil if there were none.
+// A single rpc-error is returned as *RPCError and several as *RPCErrors, both support errors.Is with an ErrorTag
+// and errors.As with *RPCError. Warnings are available from the reply of Call.
 func (s *Session) CallSimple(request interface{}) error {
+	return s.CallSimpleContext(context.Background(), request)
+}
+
+// CallSimpleContext calls a NETCONF RPC and returns an error for rpc-errors of severity error like CallSimple
+func (s *Session) CallSimpleContext(ctx context.Context, request interface{}) error {
 	reply := &RPCReply{}
-	err := s.Call(request, reply)
-	if err == nil && len(reply.RPCError) > 0 {
-		err = &reply.RPCError[0]
+	err := s.CallContext(ctx, request, reply)
+	if err == nil {
+		err = reply.Err()
+	}
+
+	// Keep returning a single rpc-error as *RPCError like before
+	if errs, ok := err.(*RPCErrors); ok && len(errs.Errors) == 1 {
+		err = &errs.Errors[0]
 	}
 	return err
 }
 
 // Close the session gracefully
 func (s *Session) Close() error {
+	return s.CloseContext(context.Background())
+}
+
+// CloseContext closes the session gracefully, the transport is closed even if the context ends first
+func (s *Session) CloseContext(ctx context.Context) error {
 	closeSession := &struct {
 		XMLName xml.Name `xml:"close-session"`
 	}{}
-	err := s.CallSimple(closeSession)
+	err := s.CallSimpleContext(ctx, closeSession)
+	if s.aborted() != nil {
+		return err
+	}
 	errTransport := s.transport.Close()
 	if err == nil {
 		err = errTransport
@@ -0,0 +1,151 @@
+/**
+ * Copyright (c) 2019-2020 Cisco Systems
+ *
+ * Author: Steven Barth <stbarth@cisco.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package netconf
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+// closeTransport records if it was closed
+type closeTransport struct {
+	net.Conn
+	closed bool
+}
+
+func (t *closeTransport) Close() error {
+	t.closed = true
+	return nil
+}
+
+func TestCallContext(t *testing.T) {
+	release := make(chan struct{})
+	server := NewServer()
+	server.Handle(xml.Name{Space: NsNetconf, Local: "lock"}, func(s *ServerSession, r *ServerRequest) (interface{}, error) {
+		<-release
+		return nil, nil
+	})
+	defer close(release)
+	session := startPipeServer(t, server)
+
+	// An already ended context does not affect the session
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := session.CallContext(ctx, &Lock{Target: Running}, &RPCReply{}); err != context.Canceled {
+		t.Fatalf("Expected cancellation, got %v", err)
+	} else if err := session.aborted(); err != nil {
+		t.Fatalf("Session aborted: %v", err)
+	}
+
+	// A context ending during the call aborts and poisons the session
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := session.CallContext(ctx, &Lock{Target: Running}, &RPCReply{}); err != context.DeadlineExceeded {
+		t.Fatalf("Expected deadline exceeded, got %v", err)
+	} else if err := session.CallSimple(&Unlock{Target: Running}); err != ErrSessionAborted {
+		t.Fatalf("Expected aborted session, got %v", err)
+	}
+}
+
+func TestWithContextCompleted(t *testing.T) {
+	// An operation completing while its context ends either succeeds or aborts the session, never both
+	for i := 0; i < 100; i++ {
+		transport := &closeTransport{}
+		session := &Session{transport: transport}
+		ctx, cancel := context.WithCancel(context.Background())
+		err := session.withContext(ctx, func() error {
+			cancel()
+			return nil
+		})
+
+		if aborted := session.aborted() != nil; (err == nil) == aborted || aborted != transport.closed {
+			t.Fatalf("Operation returned %v with session aborted %t", err, aborted)
+		} else if err != nil && err != context.Canceled {
+			t.Fatalf("Unexpected error %v", err)
+		}
+	}
+
+	// Errors of operations completed before the context ends are returned as is
+	session := &Session{transport: &closeTransport{}}
+	failed := errors.New("failed")
+	if err := session.withContext(context.Background(), func() error { return failed }); err != failed {
+		t.Fatalf("Unexpected error %v", err)
+	}
+}
+
+// plainClient hides the context support of a client
+type plainClient struct {
+	Client
+}
+
+func TestNewSessionContext(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+	client := &testPipeClient{server}
+
+	session, err := NewSessionContext(context.Background(), plainClient{client})
+	if err != nil {
+		t.Fatal(err)
+	}
+	session.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := NewSessionContext(ctx, client); err != context.Canceled {
+		t.Fatalf("Expected cancellation, got %v", err)
+	}
+
+	// A peer never sending its hello
+	silent := &testSilentClient{}
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := NewSessionContext(ctx, silent); err != context.DeadlineExceeded {
+		t.Fatalf("Expected deadline exceeded, got %v", err)
+	} else if _, err := NewSessionContext(ctx, plainClient{silent}); err != context.DeadlineExceeded {
+		t.Fatalf("Expected deadline exceeded, got %v", err)
+	}
+}
+
+// testSilentClient creates sessions to a peer which never responds
+type testSilentClient struct{}
+
+func (c *testSilentClient) NewSession() (*Session, error) {
+	return c.NewSessionContext(context.Background())
+}
+
+func (c *testSilentClient) NewSessionContext(ctx context.Context) (*Session, error) {
+	client, conn := net.Pipe()
+	go func() {
+		buffer := make([]byte, 1024)
+		for {
+			if _, err := conn.Read(buffer); err != nil {
+				return
+			}
+		}
+	}()
+	return NewSessionTransport(ctx, client, SessionOptions{})
+}
+
+func (c *testSilentClient) Close() error {
+	return nil
+}
@@ -19,6 +19,7 @@
 package netconf
 
 import (
+	"context"
 	"io"
 
 	"golang.org/x/crypto/ssh"
@@ -67,12 +68,25 @@ func DialSSHWithPublicKey(addr string, username string, signer ssh.Signer, cb ss
 
 // NewSession creates a new session from the given client
 func (c *sshClient) NewSession() (*Session, error) {
+	return c.NewSessionContext(context.Background())
+}
+
+// NewSessionContext creates a new session from the given client, aborting if the context ends first
+func (c *sshClient) NewSessionContext(ctx context.Context) (*Session, error) {
+	return c.newSessionWrapped(ctx, nil, SessionOptions{})
+}
+
+func (c *sshClient) newSessionWrapped(ctx context.Context, wrap transportWrapper, options SessionOptions) (*Session, error) {
 	var s sshSessionTransport
 	var session *Session
 	var err error
 
+	if err = ctx.Err(); err != nil {
+		return nil, err
+	}
+
 	if s.sshSession, err = c.client.NewSession(); err == nil {
-		if session, err = s.init(); err == nil {
+		if session, err = s.init(ctx, wrap, options); err == nil {
 			return session, nil
 		}
 		s.sshSession.Close()
@@ -86,7 +100,7 @@ func (c *sshClient) Close() error {
 	return c.client.Close()
 }
 
-func (s *sshSessionTransport) init() (*Session, error) {
+func (s *sshSessionTransport) init(ctx context.Context, wrap transportWrapper, options SessionOptions) (*Session, error) {
 	var err error
 
 	if s.writer, err = s.sshSession.StdinPipe(); err != nil {
@@ -101,7 +115,7 @@ func (s *sshSessionTransport) init() (*Session, error) {
 		return nil, err
 	}
 
-	return newSession(s)
+	return newSession(ctx, wrap.apply(s), options)
 }
 
 func (s sshSessionTransport) Read(p []byte) (n int, err error) {
@@ -114,5 +128,8 @@ func (s sshSessionTransport) Write(p []byte) (n int, err error) {
 
 func (s sshSessionTransport) Close() error {
 	s.writer.Close()
-	return s.sshSession.Close()
+	if err := s.sshSession.Close(); err != io.EOF {
+		return err
+	}
+	return nil // Already closed by the server, e.g. after <close-session>
 }
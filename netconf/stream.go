@@ -0,0 +1,333 @@
+/**
+ * Copyright (c) 2019-2020 Cisco Systems
+ *
+ * Author: Steven Barth <stbarth@cisco.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package netconf
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"io"
+	"strings"
+)
+
+// ErrStreamMultiplexed indicates that streaming replies is not possible in multiplexed mode
+var ErrStreamMultiplexed = errors.New("Streaming replies is not supported in multiplexed mode")
+
+// ReplyStream gives streaming access to the <data> element of an rpc-reply without buffering it.
+// Either Decoder/Next or Read may be used to consume the data, but not both.
+// The session must not be used otherwise until the stream is closed.
+type ReplyStream struct {
+	session    *Session
+	reader     io.ReadCloser
+	raw        *xml.Decoder // Reads raw tokens of the reply
+	decoder    *xml.Decoder // Decodes the output of Read, created on demand
+	namespaces []xml.Attr   // Namespace declarations in scope of <data>
+	depth      int          // Element depth relative to <data>
+	buffer     bytes.Buffer
+	done       bool
+	stop       chan struct{}
+}
+
+// CallStream calls a NETCONF RPC returning data, e.g. <get> or <get-config>, and returns a stream
+// positioned inside the <data> element of the reply. If the reply contains rpc-errors of severity
+// error, an *RPCErrors is returned instead. The context also covers reading the stream.
+func (s *Session) CallStream(ctx context.Context, request interface{}) (*ReplyStream, error) {
+	if s.mux != nil {
+		return nil, ErrStreamMultiplexed
+	}
+
+	stream := &ReplyStream{session: s, stop: make(chan struct{})}
+	err := s.withContext(ctx, func() error {
+		return stream.open(request)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if ctx.Done() != nil {
+		go func() {
+			select {
+			case <-ctx.Done():
+				s.abort()
+			case <-stream.stop:
+			}
+		}()
+	}
+	return stream, nil
+}
+
+// open sends the request and reads the reply up to the start of <data>
+func (stream *ReplyStream) open(request interface{}) error {
+	s := stream.session
+	messageID, err := s.writeRPC(request, nil)
+	if err != nil {
+		return err
+	}
+
+	// Read until rpc-reply passing on notifications as Call does
+	var reply xml.StartElement
+	for {
+		reader := s.NewReader()
+		capture := &captureBuffer{}
+		decoder := xml.NewDecoder(io.TeeReader(reader, capture))
+
+		var token xml.Token
+		for err == nil {
+			if token, err = decoder.RawToken(); err != nil {
+				break
+			} else if _, ok := token.(xml.StartElement); ok {
+				break
+			}
+		}
+
+		if err != nil {
+			reader.Close()
+			return err
+		}
+
+		element := token.(xml.StartElement)
+		if element.Name.Local == "notification" {
+			if _, err = io.Copy(capture, reader); err == nil {
+				s.handleNotification(capture.Bytes())
+			}
+		} else if element.Name.Local == "rpc-reply" {
+			stream.reader, stream.raw, reply = reader, decoder, element
+			capture.disabled = true
+			break
+		}
+
+		if errReader := reader.Close(); err == nil {
+			err = errReader
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if received := attrValue(reply, "message-id"); received != messageID {
+		err := &MessageIDError{Expected: messageID, Received: received}
+		s.fail(err)
+		stream.reader.Close()
+		return err
+	}
+	stream.namespaces = namespaceDeclarations(nil, reply)
+
+	// Collect rpc-errors until <data> starts
+	rpcReply := &RPCReply{}
+	for {
+		token, err := stream.raw.RawToken()
+		if err != nil {
+			stream.reader.Close()
+			return err
+		}
+
+		switch element := token.(type) {
+		case xml.StartElement:
+			if element.Name.Local == "data" {
+				stream.namespaces = namespaceDeclarations(stream.namespaces, element)
+				return nil
+			}
+
+			buffer := &bytes.Buffer{}
+			if err = stream.copyElement(buffer, element); err == nil && element.Name.Local == "rpc-error" &&
+				stream.namespace(element) == NsNetconf {
+				rpcError := RPCError{}
+				err = xml.Unmarshal(buffer.Bytes(), &rpcError)
+				rpcReply.RPCError = append(rpcReply.RPCError, rpcError)
+			}
+			if err != nil {
+				stream.reader.Close()
+				return err
+			}
+		case xml.EndElement:
+			stream.reader.Close()
+			if err := rpcReply.Err(); err != nil {
+				return err
+			}
+			return &MalformedReplyError{MessageID: messageID, Reason: "no data element"}
+		}
+	}
+}
+
+// namespaceDeclarations appends the namespace declarations of an element
+func namespaceDeclarations(namespaces []xml.Attr, element xml.StartElement) []xml.Attr {
+	for _, attr := range element.Attr {
+		if attr.Name.Space == "xmlns" || (attr.Name.Space == "" && attr.Name.Local == "xmlns") {
+			namespaces = append(namespaces, attr)
+		}
+	}
+	return namespaces
+}
+
+// namespace resolves the namespace of an element of the reply outside of <data>
+func (stream *ReplyStream) namespace(element xml.StartElement) string {
+	name := xml.Name{Space: "xmlns", Local: element.Name.Space}
+	if len(element.Name.Space) == 0 {
+		name = xml.Name{Local: "xmlns"}
+	}
+
+	namespaces := namespaceDeclarations(stream.namespaces[:len(stream.namespaces):len(stream.namespaces)], element)
+	for i := len(namespaces) - 1; i >= 0; i-- {
+		if namespaces[i].Name == name {
+			return namespaces[i].Value
+		}
+	}
+	return ""
+}
+
+// copyElement writes a raw element of the reply with the namespace declarations in scope
+func (stream *ReplyStream) copyElement(buffer *bytes.Buffer, element xml.StartElement) error {
+	element.Attr = stream.inheritNamespaces(element.Attr)
+	writeToken(buffer, element)
+	for depth := 1; depth > 0; {
+		token, err := stream.raw.RawToken()
+		if err != nil {
+			return err
+		}
+
+		switch token.(type) {
+		case xml.StartElement:
+			depth++
+		case xml.EndElement:
+			depth--
+		}
+		writeToken(buffer, token)
+	}
+	return nil
+}
+
+// Decoder returns a decoder for the content of <data> as returned by Read, it reaches io.EOF after the last element
+func (stream *ReplyStream) Decoder() *xml.Decoder {
+	if stream.decoder == nil {
+		stream.decoder = xml.NewDecoder(stream)
+	}
+	return stream.decoder
+}
+
+// Next decodes the next top-level element of <data> into v and returns io.EOF after the last one
+func (stream *ReplyStream) Next(v interface{}) error {
+	decoder := stream.Decoder()
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return err
+		} else if element, ok := token.(xml.StartElement); ok {
+			return decoder.DecodeElement(v, &element)
+		}
+	}
+}
+
+// Read the raw XML content of <data>. Namespace declarations of enclosing elements are
+// copied to top-level elements so that each of them can be parsed on its own.
+func (stream *ReplyStream) Read(p []byte) (int, error) {
+	for stream.buffer.Len() == 0 && !stream.done {
+		token, err := stream.raw.RawToken()
+		if err != nil {
+			return 0, err
+		}
+
+		switch element := token.(type) {
+		case xml.StartElement:
+			if stream.depth == 0 {
+				element.Attr = stream.inheritNamespaces(element.Attr)
+			}
+			stream.depth++
+			token = element
+		case xml.EndElement:
+			if stream.depth == 0 {
+				stream.done = true
+				continue
+			}
+			stream.depth--
+		}
+		writeToken(&stream.buffer, token)
+	}
+
+	if stream.buffer.Len() == 0 {
+		return 0, io.EOF
+	}
+	return stream.buffer.Read(p)
+}
+
+// inheritNamespaces adds namespace declarations in scope which are not overridden by the element
+func (stream *ReplyStream) inheritNamespaces(attrs []xml.Attr) []xml.Attr {
+	inherited := make([]xml.Attr, 0, len(stream.namespaces)+len(attrs))
+	for i := len(stream.namespaces) - 1; i >= 0; i-- {
+		declaration := stream.namespaces[i]
+		declared := false
+		for _, attr := range append(inherited, attrs...) {
+			if attr.Name == declaration.Name {
+				declared = true
+				break
+			}
+		}
+		if !declared {
+			inherited = append(inherited, declaration)
+		}
+	}
+	return append(inherited, attrs...)
+}
+
+func qualifiedName(name xml.Name) string {
+	if len(name.Space) > 0 {
+		return name.Space + ":" + name.Local
+	}
+	return name.Local
+}
+
+// textEscaper escapes character data keeping whitespace unlike xml.EscapeText
+var textEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+// writeToken serializes a raw token
+func writeToken(buffer *bytes.Buffer, token xml.Token) {
+	switch token := token.(type) {
+	case xml.StartElement:
+		buffer.WriteString("<" + qualifiedName(token.Name))
+		for _, attr := range token.Attr {
+			buffer.WriteString(" " + qualifiedName(attr.Name) + "=\"")
+			xml.EscapeText(buffer, []byte(attr.Value))
+			buffer.WriteByte('"')
+		}
+		buffer.WriteByte('>')
+	case xml.EndElement:
+		buffer.WriteString("</" + qualifiedName(token.Name) + ">")
+	case xml.CharData:
+		textEscaper.WriteString(buffer, string(token))
+	case xml.Comment:
+		buffer.WriteString("<!--")
+		buffer.Write(token)
+		buffer.WriteString("-->")
+	case xml.ProcInst:
+		buffer.WriteString("<?" + token.Target + " ")
+		buffer.Write(token.Inst)
+		buffer.WriteString("?>")
+	}
+}
+
+// Close the stream and skip the remainder of the reply
+func (stream *ReplyStream) Close() error {
+	select {
+	case <-stream.stop:
+		return nil
+	default:
+		close(stream.stop)
+	}
+	return stream.reader.Close()
+}
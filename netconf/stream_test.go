@@ -0,0 +1,134 @@
+/**
+ * Copyright (c) 2019-2020 Cisco Systems
+ *
+ * Author: Steven Barth <stbarth@cisco.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package netconf
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+// testStreamReply uses nested and prefixed namespace declarations around and inside <data>
+const testStreamReply = `<nc:rpc-reply xmlns:nc="urn:ietf:params:xml:ns:netconf:base:1.0" xmlns:if="urn:if" message-id="%s">` +
+	`<nc:rpc-error><nc:error-type>application</nc:error-type><nc:error-tag>operation-failed</nc:error-tag>` +
+	`<nc:error-severity>warning</nc:error-severity></nc:rpc-error>` +
+	`<nc:data xmlns="urn:default"><if:interfaces><if:interface><if:name>a&amp;b</if:name>` +
+	`<x:type xmlns:x="urn:x">eth</x:type></if:interface></if:interfaces><system xmlns:if="urn:other"><if:name/></system></nc:data></nc:rpc-reply>`
+
+// serveTestPeer answers each request with the next reply, %s is replaced by the message-id
+func serveTestPeer(t *testing.T, peer *testPeer, replies ...string) {
+	go func() {
+		for _, reply := range replies {
+			request, err := peer.read()
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			messageID := testMessageID.FindStringSubmatch(request)[1]
+			if err := peer.write(strings.Replace(reply, "%s", messageID, 1)); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+}
+
+func TestReplyStream(t *testing.T) {
+	session, peer := newTestPeer(t)
+	serveTestPeer(t, peer, testStreamReply, testStreamReply, testStreamReply,
+		`<rpc-reply xmlns="urn:ietf:params:xml:ns:netconf:base:1.0" message-id="%s"><ok/></rpc-reply>`,
+		`<rpc-reply xmlns="urn:ietf:params:xml:ns:netconf:base:1.0" message-id="%s"><rpc-error>`+
+			`<error-type>protocol</error-type><error-tag>access-denied</error-tag><error-severity>error</error-severity>`+
+			`</rpc-error></rpc-reply>`,
+		"<rpc-reply xmlns=\"urn:ietf:params:xml:ns:netconf:base:1.0\" message-id=\"%s\">\n<data>\n  <a xmlns=\"urn:a\">\n"+
+			"\t<b>x &lt; y &amp;&amp; y &gt; z</b>\n  </a>\n</data>\n</rpc-reply>")
+
+	// Top-level elements carry all namespace declarations in scope
+	stream, err := session.CallStream(context.Background(), &Get{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(stream)
+	if err != nil {
+		t.Fatal(err)
+	} else if expected := `<if:interfaces xmlns="urn:default" xmlns:if="urn:if" xmlns:nc="urn:ietf:params:xml:ns:netconf:base:1.0">` +
+		`<if:interface><if:name>a&amp;b</if:name><x:type xmlns:x="urn:x">eth</x:type></if:interface></if:interfaces>` +
+		`<system xmlns="urn:default" xmlns:nc="urn:ietf:params:xml:ns:netconf:base:1.0" xmlns:if="urn:other"><if:name></if:name></system>`; string(data) != expected {
+		t.Fatalf("Unexpected data %s", data)
+	} else if err := stream.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Decoding resolves the namespaces of enclosing elements
+	stream, err = session.CallStream(context.Background(), &Get{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	interfaces := &struct {
+		XMLName xml.Name `xml:"urn:if interfaces"`
+		Name    string   `xml:"urn:if interface>name"`
+		Type    string   `xml:"urn:x interface>type"`
+	}{}
+	system := &struct {
+		XMLName xml.Name `xml:"urn:default system"`
+		Name    *string  `xml:"urn:other name"`
+	}{}
+	if err := stream.Next(interfaces); err != nil || interfaces.Name != "a&b" || interfaces.Type != "eth" {
+		t.Fatalf("Unexpected interfaces %+v: %v", interfaces, err)
+	} else if err := stream.Next(system); err != nil || system.Name == nil {
+		t.Fatalf("Unexpected system %+v: %v", system, err)
+	} else if err := stream.Next(system); err != io.EOF {
+		t.Fatalf("Expected end of stream, got %v", err)
+	} else if err := stream.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Closing early skips the remaining reply and keeps the session usable
+	stream, err = session.CallStream(context.Background(), &Get{})
+	if err != nil {
+		t.Fatal(err)
+	} else if token, err := stream.Decoder().Token(); err != nil {
+		t.Fatal(err)
+	} else if element, ok := token.(xml.StartElement); !ok || element.Name.Space != "urn:if" {
+		t.Fatalf("Unexpected token %#v", token)
+	} else if err := stream.Close(); err != nil {
+		t.Fatal(err)
+	} else if err := session.CallSimple(&Lock{Target: Running}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := session.CallStream(context.Background(), &Get{}); !errors.Is(err, TagAccessDenied) {
+		t.Fatalf("Expected access denied, got %v", err)
+	}
+
+	// Whitespace of pretty-printed replies is preserved
+	stream, err = session.CallStream(context.Background(), &Get{})
+	if err != nil {
+		t.Fatal(err)
+	} else if data, err := io.ReadAll(stream); err != nil {
+		t.Fatal(err)
+	} else if expected := "\n  <a xmlns=\"urn:a\">\n\t<b>x &lt; y &amp;&amp; y &gt; z</b>\n  </a>\n"; string(data) != expected {
+		t.Fatalf("Unexpected data %q", data)
+	} else if err := stream.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,359 @@
+/**
+ * Copyright (c) 2019-2020 Cisco Systems
+ *
+ * Author: Steven Barth <stbarth@cisco.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package netconf
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrSubscriptionNotMultiplexed indicates that a subscription was requested on a session not in multiplexed mode
+var ErrSubscriptionNotMultiplexed = errors.New("Subscriptions require a session in multiplexed mode")
+
+// ErrKillOwnSubscription indicates an attempt to kill a subscription of the same session, it must be deleted instead
+var ErrKillOwnSubscription = errors.New("Subscriptions of the same session cannot be killed, delete them instead")
+
+// SubtreeFilter holds a subtree filter as raw XML
+type SubtreeFilter struct {
+	InnerXML []byte `xml:",innerxml"`
+}
+
+// EstablishSubscription defines the <establish-subscription> operation (RFC 8639) for use with Session.Call
+type EstablishSubscription struct {
+	XMLName             xml.Name       `xml:"urn:ietf:params:xml:ns:yang:ietf-subscribed-notifications establish-subscription"`
+	Stream              *string        `xml:"urn:ietf:params:xml:ns:yang:ietf-subscribed-notifications stream,omitempty"`
+	StreamFilterName    *string        `xml:"urn:ietf:params:xml:ns:yang:ietf-subscribed-notifications stream-filter-name,omitempty"`
+	StreamSubtreeFilter *SubtreeFilter `xml:"urn:ietf:params:xml:ns:yang:ietf-subscribed-notifications stream-subtree-filter,omitempty"`
+	StreamXPathFilter   *string        `xml:"urn:ietf:params:xml:ns:yang:ietf-subscribed-notifications stream-xpath-filter,omitempty"`
+	ReplayStartTime     *time.Time     `xml:"urn:ietf:params:xml:ns:yang:ietf-subscribed-notifications replay-start-time,omitempty"`
+	StopTime            *time.Time     `xml:"urn:ietf:params:xml:ns:yang:ietf-subscribed-notifications stop-time,omitempty"`
+	DSCP                *uint8         `xml:"urn:ietf:params:xml:ns:yang:ietf-subscribed-notifications dscp,omitempty"`
+	Weighting           *uint8         `xml:"urn:ietf:params:xml:ns:yang:ietf-subscribed-notifications weighting,omitempty"`
+	Dependency          *uint32        `xml:"urn:ietf:params:xml:ns:yang:ietf-subscribed-notifications dependency,omitempty"`
+	Encoding            string         `xml:"urn:ietf:params:xml:ns:yang:ietf-subscribed-notifications encoding,omitempty"`
+
+	// Augmentations from ietf-yang-push (RFC 8641)
+	Datastore              *DatastoreIdentity `xml:"urn:ietf:params:xml:ns:yang:ietf-yang-push datastore,omitempty"`
+	DatastoreSubtreeFilter *SubtreeFilter     `xml:"urn:ietf:params:xml:ns:yang:ietf-yang-push datastore-subtree-filter,omitempty"`
+	DatastoreXPathFilter   *string            `xml:"urn:ietf:params:xml:ns:yang:ietf-yang-push datastore-xpath-filter,omitempty"`
+	Periodic               *Periodic          `xml:"urn:ietf:params:xml:ns:yang:ietf-yang-push periodic,omitempty"`
+	OnChange               *OnChange          `xml:"urn:ietf:params:xml:ns:yang:ietf-yang-push on-change,omitempty"`
+
+	Extensions []byte `xml:",innerxml"` // Other augmentations
+}
+
+// EstablishSubscriptionReply models the reply to <establish-subscription>
+type EstablishSubscriptionReply struct {
+	RPCReply
+	ID                      uint32     `xml:"urn:ietf:params:xml:ns:yang:ietf-subscribed-notifications id"`
+	ReplayStartTimeRevision *time.Time `xml:"urn:ietf:params:xml:ns:yang:ietf-subscribed-notifications replay-start-time-revision"`
+}
+
+// ModifySubscription defines the <modify-subscription> operation (RFC 8639) for use with Session.CallSimple
+type ModifySubscription struct {
+	XMLName             xml.Name       `xml:"urn:ietf:params:xml:ns:yang:ietf-subscribed-notifications modify-subscription"`
+	ID                  uint32         `xml:"urn:ietf:params:xml:ns:yang:ietf-subscribed-notifications id"`
+	StreamFilterName    *string        `xml:"urn:ietf:params:xml:ns:yang:ietf-subscribed-notifications stream-filter-name,omitempty"`
+	StreamSubtreeFilter *SubtreeFilter `xml:"urn:ietf:params:xml:ns:yang:ietf-subscribed-notifications stream-subtree-filter,omitempty"`
+	StreamXPathFilter   *string        `xml:"urn:ietf:params:xml:ns:yang:ietf-subscribed-notifications stream-xpath-filter,omitempty"`
+	StopTime            *time.Time     `xml:"urn:ietf:params:xml:ns:yang:ietf-subscribed-notifications stop-time,omitempty"`
+
+	// Augmentations from ietf-yang-push (RFC 8641)
+	Datastore              *DatastoreIdentity `xml:"urn:ietf:params:xml:ns:yang:ietf-yang-push datastore,omitempty"`
+	DatastoreSubtreeFilter *SubtreeFilter     `xml:"urn:ietf:params:xml:ns:yang:ietf-yang-push datastore-subtree-filter,omitempty"`
+	DatastoreXPathFilter   *string            `xml:"urn:ietf:params:xml:ns:yang:ietf-yang-push datastore-xpath-filter,omitempty"`
+	Periodic               *Periodic          `xml:"urn:ietf:params:xml:ns:yang:ietf-yang-push periodic,omitempty"`
+	OnChange               *OnChange          `xml:"urn:ietf:params:xml:ns:yang:ietf-yang-push on-change,omitempty"`
+
+	Extensions []byte `xml:",innerxml"` // Other augmentations
+}
+
+// DeleteSubscription defines the <delete-subscription> operation (RFC 8639) for use with Session.CallSimple
+type DeleteSubscription struct {
+	XMLName xml.Name `xml:"urn:ietf:params:xml:ns:yang:ietf-subscribed-notifications delete-subscription"`
+	ID      uint32   `xml:"urn:ietf:params:xml:ns:yang:ietf-subscribed-notifications id"`
+}
+
+// KillSubscription defines the <kill-subscription> operation (RFC 8639) for use with Session.CallSimple
+type KillSubscription struct {
+	XMLName xml.Name `xml:"urn:ietf:params:xml:ns:yang:ietf-subscribed-notifications kill-subscription"`
+	ID      uint32   `xml:"urn:ietf:params:xml:ns:yang:ietf-subscribed-notifications id"`
+}
+
+// SubscriptionState identifies a subscription state change notification (RFC 8639)
+type SubscriptionState string
+
+// List of subscription state change notifications
+const (
+	SubscriptionStarted    SubscriptionState = "subscription-started"
+	SubscriptionModified   SubscriptionState = "subscription-modified"
+	SubscriptionTerminated SubscriptionState = "subscription-terminated"
+	SubscriptionSuspended  SubscriptionState = "subscription-suspended"
+	SubscriptionResumed    SubscriptionState = "subscription-resumed"
+	ReplayCompleted        SubscriptionState = "replay-completed"
+)
+
+// SubscriptionStateChange describes a parsed subscription state change notification
+type SubscriptionStateChange struct {
+	State     SubscriptionState
+	EventTime time.Time
+	ID        uint32
+	Reason    string // Identity for terminated and suspended notifications
+	InnerXML  []byte // Content of the state change element, e.g. filters of started or modified
+}
+
+// ParseSubscriptionStateChange parses a subscription state change notification, returns nil for other notifications
+func ParseSubscriptionStateChange(notification *RawNotification) *SubscriptionStateChange {
+	content := &struct {
+		XMLName xml.Name `xml:"urn:ietf:params:xml:ns:netconf:notification:1.0 notification"`
+		Events  []struct {
+			XMLName  xml.Name
+			ID       uint32 `xml:"id"`
+			Reason   string `xml:"reason"`
+			InnerXML []byte `xml:",innerxml"`
+		} `xml:",any"`
+	}{}
+	if notification.Decode(content) != nil {
+		return nil
+	}
+
+	for _, event := range content.Events {
+		if event.XMLName.Space != NsSubscribedNotifications {
+			continue
+		}
+
+		switch state := SubscriptionState(event.XMLName.Local); state {
+		case SubscriptionStarted, SubscriptionModified, SubscriptionTerminated,
+			SubscriptionSuspended, SubscriptionResumed, ReplayCompleted:
+			return &SubscriptionStateChange{
+				State:     state,
+				EventTime: notification.EventTime,
+				ID:        event.ID,
+				Reason:    event.Reason,
+				InnerXML:  event.InnerXML,
+			}
+		}
+	}
+	return nil
+}
+
+// subscriptionID extracts the subscription ID carried by subscription-related notifications
+func subscriptionID(notification *RawNotification) (uint32, bool) {
+	content := &struct {
+		XMLName xml.Name `xml:"urn:ietf:params:xml:ns:netconf:notification:1.0 notification"`
+		Events  []struct {
+			XMLName xml.Name
+			ID      *uint32 `xml:"id"`
+		} `xml:",any"`
+	}{}
+	if notification.Decode(content) != nil {
+		return 0, false
+	}
+
+	for _, event := range content.Events {
+		if event.ID != nil && subscriptionNamespaces[event.XMLName.Space] {
+			return *event.ID, true
+		}
+	}
+	return 0, false
+}
+
+// Namespaces of notifications which carry the subscription ID
+var subscriptionNamespaces = map[string]bool{
+	NsSubscribedNotifications: true,
+	NsYangPush:                true,
+}
+
+// Subscription represents a dynamic subscription (RFC 8639) established on a session
+type Subscription struct {
+	ID uint32
+
+	session       *Session
+	notifications chan *RawNotification
+	mutex         sync.Mutex
+	queue         []*RawNotification
+	established   bool // ID is known, protected by the mutex
+	signal        chan struct{}
+	done          chan struct{}
+	closeOnce     sync.Once
+	remove        func()
+}
+
+// EstablishSubscription establishes a dynamic subscription. The session must have been switched into
+// multiplexed mode using Multiplex before, so that notifications are delivered in the background.
+// Notifications carrying a subscription ID are routed to the matching subscription,
+// all others are delivered to every subscription of the session.
+func (s *Session) EstablishSubscription(ctx context.Context, request *EstablishSubscription) (*Subscription, error) {
+	if s.mux == nil {
+		return nil, ErrSubscriptionNotMultiplexed
+	}
+
+	subscription := &Subscription{
+		session:       s,
+		notifications: make(chan *RawNotification),
+		signal:        make(chan struct{}, 1),
+		done:          make(chan struct{}),
+	}
+
+	// Register before establishing to not miss early notifications, they are queued until the ID is known
+	subscription.remove = s.OnNotification(subscription.enqueue)
+	established := make(chan struct{})
+	go subscription.run(established)
+
+	reply := &EstablishSubscriptionReply{}
+	err := s.CallContext(ctx, request, reply)
+	if err == nil {
+		err = reply.Err()
+	}
+	if err != nil {
+		subscription.close()
+		return nil, err
+	}
+
+	subscription.mutex.Lock()
+	subscription.ID, subscription.established = reply.ID, true
+	subscription.mutex.Unlock()
+
+	s.notificationMutex.Lock()
+	if s.subscriptions == nil {
+		s.subscriptions = make(map[uint32]*Subscription)
+	}
+	s.subscriptions[subscription.ID] = subscription
+	s.notificationMutex.Unlock()
+
+	close(established)
+	return subscription, nil
+}
+
+// enqueue is the notification handler of the subscription and never blocks the session.
+// Notifications of other subscriptions are skipped once the ID is known, the oldest ones are dropped if too many wait.
+func (sub *Subscription) enqueue(notification *RawNotification) {
+	id, hasID := subscriptionID(notification)
+
+	sub.mutex.Lock()
+	if sub.established && hasID && id != sub.ID {
+		sub.mutex.Unlock()
+		return
+	} else if len(sub.queue) >= maxNotificationBacklog {
+		sub.queue = append(sub.queue[:0], sub.queue[1:]...)
+	}
+	sub.queue = append(sub.queue, notification)
+	sub.mutex.Unlock()
+
+	select {
+	case sub.signal <- struct{}{}:
+	default:
+	}
+}
+
+// run passes queued notifications belonging to this subscription to the subscriber
+func (sub *Subscription) run(established <-chan struct{}) {
+	defer close(sub.notifications)
+
+	select {
+	case <-established:
+	case <-sub.done:
+		return
+	}
+
+	for {
+		sub.mutex.Lock()
+		if len(sub.queue) == 0 {
+			sub.mutex.Unlock()
+			select {
+			case <-sub.signal:
+			case <-sub.session.mux.done:
+				sub.close()
+				return
+			case <-sub.done:
+				return
+			}
+			continue
+		}
+		notification := sub.queue[0]
+		sub.queue = sub.queue[1:]
+		sub.mutex.Unlock()
+
+		if id, ok := subscriptionID(notification); ok && id != sub.ID {
+			continue
+		}
+
+		select {
+		case sub.notifications <- notification:
+		case <-sub.done:
+			return
+		}
+
+		if change := ParseSubscriptionStateChange(notification); change != nil &&
+			change.State == SubscriptionTerminated {
+			sub.close()
+			return
+		}
+	}
+}
+
+// close stops delivery, the notification channel is closed subsequently
+func (sub *Subscription) close() {
+	sub.closeOnce.Do(func() {
+		close(sub.done)
+		sub.remove()
+
+		sub.session.notificationMutex.Lock()
+		if sub.session.subscriptions[sub.ID] == sub {
+			delete(sub.session.subscriptions, sub.ID)
+		}
+		sub.session.notificationMutex.Unlock()
+	})
+}
+
+// Notifications returns the notifications of the subscription including its state changes.
+// The channel is closed once the subscription was terminated or deleted.
+func (sub *Subscription) Notifications() <-chan *RawNotification {
+	return sub.notifications
+}
+
+// Modify the subscription, the ID of the request is set automatically
+func (sub *Subscription) Modify(ctx context.Context, request *ModifySubscription) error {
+	request.ID = sub.ID
+	return sub.session.CallSimpleContext(ctx, request)
+}
+
+// Delete the subscription
+func (sub *Subscription) Delete(ctx context.Context) error {
+	err := sub.session.CallSimpleContext(ctx, &DeleteSubscription{ID: sub.ID})
+	if err == nil {
+		sub.close()
+	}
+	return err
+}
+
+// KillSubscription kills a dynamic subscription of another session. As required by RFC 8639 subscriptions
+// of this session are rejected with ErrKillOwnSubscription, they are ended using Subscription.Delete.
+func (s *Session) KillSubscription(ctx context.Context, id uint32) error {
+	s.notificationMutex.Lock()
+	_, own := s.subscriptions[id]
+	s.notificationMutex.Unlock()
+	if own {
+		return ErrKillOwnSubscription
+	}
+	return s.CallSimpleContext(ctx, &KillSubscription{ID: id})
+}
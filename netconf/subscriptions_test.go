@@ -0,0 +1,165 @@
+/**
+ * Copyright (c) 2019-2020 Cisco Systems
+ *
+ * Author: Steven Barth <stbarth@cisco.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package netconf
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"testing"
+	"time"
+)
+
+// testSubscriptionEvent is a notification carrying a subscription ID like yang-push updates
+type testSubscriptionEvent struct {
+	XMLName xml.Name `xml:"urn:ietf:params:xml:ns:yang:ietf-yang-push push-update"`
+	ID      uint32   `xml:"id"`
+}
+
+func TestSubscriptions(t *testing.T) {
+	nextID := uint32(0)
+	server := NewServer()
+	server.Handle(xml.Name{Space: NsSubscribedNotifications, Local: "establish-subscription"}, func(s *ServerSession, r *ServerRequest) (interface{}, error) {
+		nextID++
+		return &struct {
+			XMLName xml.Name `xml:"urn:ietf:params:xml:ns:yang:ietf-subscribed-notifications id"`
+			ID      uint32   `xml:",chardata"`
+		}{ID: nextID}, nil
+	})
+	server.Handle(xml.Name{Space: NsSubscribedNotifications, Local: "delete-subscription"}, func(s *ServerSession, r *ServerRequest) (interface{}, error) {
+		return nil, nil
+	})
+	server.Handle(xml.Name{Space: NsSubscribedNotifications, Local: "kill-subscription"}, func(s *ServerSession, r *ServerRequest) (interface{}, error) {
+		return nil, nil
+	})
+
+	// Trigger notifications of both subscriptions and terminate the second one
+	server.Handle(xml.Name{Space: "urn:example", Local: "trigger"}, func(s *ServerSession, r *ServerRequest) (interface{}, error) {
+		s.Notify(time.Now(), &testSubscriptionEvent{ID: 2})
+		s.Notify(time.Now(), &testSubscriptionEvent{ID: 1})
+		s.Notify(time.Now(), &struct {
+			XMLName xml.Name `xml:"urn:ietf:params:xml:ns:yang:ietf-subscribed-notifications subscription-terminated"`
+			ID      uint32   `xml:"id"`
+			Reason  string   `xml:"reason"`
+		}{ID: 2, Reason: "sn:no-such-subscription"})
+		return nil, nil
+	})
+	session := startPipeServer(t, server)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	stream := "NETCONF"
+	if _, err := session.EstablishSubscription(ctx, &EstablishSubscription{Stream: &stream}); err != ErrSubscriptionNotMultiplexed {
+		t.Fatalf("Expected error for sequential session, got %v", err)
+	}
+
+	session.Multiplex()
+	first, err := session.EstablishSubscription(ctx, &EstablishSubscription{Stream: &stream})
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := session.EstablishSubscription(ctx, &EstablishSubscription{Stream: &stream})
+	if err != nil {
+		t.Fatal(err)
+	} else if first.ID != 1 || second.ID != 2 {
+		t.Fatalf("Unexpected subscription IDs %d and %d", first.ID, second.ID)
+	}
+
+	trigger := &struct {
+		XMLName xml.Name `xml:"urn:example trigger"`
+	}{}
+	if err := session.CallSimpleContext(ctx, trigger); err != nil {
+		t.Fatal(err)
+	}
+
+	// Notifications are routed by subscription ID, terminated subscriptions are closed
+	if notification := <-first.Notifications(); !bytes.Contains(notification.XML, []byte("<id>1</id>")) {
+		t.Fatalf("Unexpected notification %s", notification.XML)
+	} else if notification := <-second.Notifications(); !bytes.Contains(notification.XML, []byte("<id>2</id>")) {
+		t.Fatalf("Unexpected notification %s", notification.XML)
+	} else if change := ParseSubscriptionStateChange(<-second.Notifications()); change == nil || change.State != SubscriptionTerminated {
+		t.Fatalf("Unexpected state change %+v", change)
+	} else if _, ok := <-second.Notifications(); ok {
+		t.Fatal("Terminated subscription not closed")
+	}
+
+	// Own subscriptions cannot be killed while established, only deleted
+	if err := session.KillSubscription(ctx, first.ID); err != ErrKillOwnSubscription {
+		t.Fatalf("Expected own subscription error, got %v", err)
+	} else if err := session.KillSubscription(ctx, second.ID); err != nil {
+		t.Fatal(err)
+	} else if err := first.Delete(ctx); err != nil {
+		t.Fatal(err)
+	} else if _, ok := <-first.Notifications(); ok {
+		t.Fatal("Deleted subscription not closed")
+	} else if err := session.KillSubscription(ctx, first.ID); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSubscriptionBacklog(t *testing.T) {
+	server := NewServer()
+	server.Handle(xml.Name{Space: NsSubscribedNotifications, Local: "establish-subscription"}, func(s *ServerSession, r *ServerRequest) (interface{}, error) {
+		return &struct {
+			XMLName xml.Name `xml:"urn:ietf:params:xml:ns:yang:ietf-subscribed-notifications id"`
+			ID      uint32   `xml:",chardata"`
+		}{ID: 1}, nil
+	})
+	server.Handle(xml.Name{Space: "urn:example", Local: "trigger"}, func(s *ServerSession, r *ServerRequest) (interface{}, error) {
+		for i := 0; i < 2*maxNotificationBacklog; i++ {
+			s.Notify(time.Now(), &testSubscriptionEvent{ID: 2})
+		}
+		for i := 0; i < 3*maxNotificationBacklog; i++ {
+			s.Notify(time.Now(), &testSubscriptionEvent{ID: 1})
+		}
+		return nil, nil
+	})
+	session := startPipeServer(t, server)
+	session.Multiplex()
+
+	stream := "NETCONF"
+	subscription, err := session.EstablishSubscription(context.Background(), &EstablishSubscription{Stream: &stream})
+	if err != nil {
+		t.Fatal(err)
+	} else if err := session.CallSimple(&struct {
+		XMLName xml.Name `xml:"urn:example trigger"`
+	}{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Without a reader only the latest notifications of the subscription are retained
+	for deadline := time.Now().Add(10 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		subscription.mutex.Lock()
+		queue := append([]*RawNotification(nil), subscription.queue...)
+		subscription.mutex.Unlock()
+
+		if len(queue) > maxNotificationBacklog {
+			t.Fatalf("Backlog of %d notifications", len(queue))
+		} else if len(queue) == maxNotificationBacklog {
+			for _, notification := range queue {
+				if id, ok := subscriptionID(notification); !ok || id != 1 {
+					t.Fatalf("Unexpected notification %s", notification.XML)
+				}
+			}
+			break
+		} else if time.Now().After(deadline) {
+			t.Fatalf("Backlog of %d notifications only", len(queue))
+		}
+	}
+}
@@ -0,0 +1,228 @@
+/**
+ * Copyright (c) 2019-2020 Cisco Systems
+ *
+ * Author: Steven Barth <stbarth@cisco.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package netconf
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"sync"
+)
+
+// ErrTLSSessionInUse indicates that a NETCONF over TLS connection already carries a session (RFC 7589)
+var ErrTLSSessionInUse = errors.New("TLS connection already carries a NETCONF session")
+
+// ErrNoCertToName indicates that no cert-to-name entry matched the peer certificate chain
+var ErrNoCertToName = errors.New("No cert-to-name mapping matched the peer certificate")
+
+// CertToNameMapType defines how a NETCONF identity is derived from a certificate (RFC 7407)
+type CertToNameMapType string
+
+// List of cert-to-name map types defined in RFC 7407
+const (
+	MapSpecified     CertToNameMapType = "specified"
+	MapSANRFC822Name CertToNameMapType = "san-rfc822-name"
+	MapSANDNSName    CertToNameMapType = "san-dns-name"
+	MapSANIPAddress  CertToNameMapType = "san-ip-address"
+	MapSANAny        CertToNameMapType = "san-any"
+	MapCommonName    CertToNameMapType = "common-name"
+)
+
+// CertToName maps a certificate fingerprint to a NETCONF identity (RFC 7407)
+type CertToName struct {
+	Fingerprint string
+	MapType     CertToNameMapType
+	Name        string
+}
+
+// TLSIdentity describes the identity of a NETCONF over TLS peer
+type TLSIdentity struct {
+	Name        string
+	Fingerprint string
+	Certificate *x509.Certificate
+}
+
+type tlsClient struct {
+	conn  *tls.Conn
+	mutex sync.Mutex
+	used  bool
+}
+
+// NewClientTLS creates a new NETCONF TLS client from an established TLS connection
+func NewClientTLS(conn *tls.Conn) Client {
+	return &tlsClient{conn: conn}
+}
+
+// DialTLS is a convenience function to creating a new NETCONF over TLS session
+func DialTLS(addr string, config *tls.Config) (Client, error) {
+	conn, err := tls.Dial("tcp", addr, config)
+	if err != nil {
+		return nil, err
+	}
+	return NewClientTLS(conn), nil
+}
+
+// DialTLSWithCertificate is a convenience function to creating a new NETCONF over TLS session using X.509 client auth
+func DialTLSWithCertificate(addr string, cert tls.Certificate, roots *x509.CertPool, serverName string) (Client, error) {
+	return DialTLS(addr, &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		RootCAs:      roots,
+		ServerName:   serverName,
+		MinVersion:   tls.VersionTLS12,
+	})
+}
+
+// NewSession creates the NETCONF session on the TLS connection, only one session per connection is possible
+func (c *tlsClient) NewSession() (*Session, error) {
+	return c.NewSessionContext(context.Background())
+}
+
+// NewSessionContext creates the NETCONF session on the TLS connection, aborting if the context ends first
+func (c *tlsClient) NewSessionContext(ctx context.Context) (*Session, error) {
+	return c.newSessionWrapped(ctx, nil, SessionOptions{})
+}
+
+func (c *tlsClient) newSessionWrapped(ctx context.Context, wrap transportWrapper, options SessionOptions) (*Session, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.used {
+		return nil, ErrTLSSessionInUse
+	}
+	c.used = true
+
+	// The connection cannot carry another session if this one fails
+	if err := c.conn.HandshakeContext(ctx); err != nil {
+		c.conn.Close()
+		return nil, err
+	}
+
+	session, err := newSession(ctx, wrap.apply(c.conn), options)
+	if err != nil {
+		c.conn.Close()
+		return nil, err
+	}
+	return session, nil
+}
+
+// Close TLS connection
+func (c *tlsClient) Close() error {
+	return c.conn.Close()
+}
+
+// ConnectionState returns the TLS state of the underlying connection
+func (c *tlsClient) ConnectionState() tls.ConnectionState {
+	return c.conn.ConnectionState()
+}
+
+// TLSPeerIdentity returns the identity of the peer of a NETCONF over TLS client.
+// The name is derived from the first matching cert-to-name entry walking the certificate chain
+// from the leaf upwards, if no entries are given, the name is left empty.
+func TLSPeerIdentity(client Client, maps []CertToName) (*TLSIdentity, error) {
+	c, ok := client.(interface {
+		ConnectionState() tls.ConnectionState
+	})
+	if !ok {
+		return nil, errors.New("Not a NETCONF over TLS client")
+	}
+
+	chain := c.ConnectionState().PeerCertificates
+	if len(chain) == 0 {
+		return nil, errors.New("No peer certificate presented")
+	}
+
+	identity := &TLSIdentity{
+		Fingerprint: TLSFingerprint(chain[0]),
+		Certificate: chain[0],
+	}
+	if len(maps) == 0 {
+		return identity, nil
+	}
+
+	var err error
+	identity.Name, err = CertToNameLookup(chain, maps)
+	return identity, err
+}
+
+// TLSFingerprint returns the RFC 7407 tls-fingerprint (SHA-256) of a certificate
+func TLSFingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	fingerprint := make([]string, 1, len(sum)+1)
+	fingerprint[0] = "04" // TLS HashAlgorithm sha256
+	for _, b := range sum {
+		fingerprint = append(fingerprint, hex.EncodeToString([]byte{b}))
+	}
+	return strings.Join(fingerprint, ":")
+}
+
+// CertToNameLookup derives a NETCONF identity from a certificate chain (leaf first) as specified in RFC 7589
+func CertToNameLookup(chain []*x509.Certificate, maps []CertToName) (string, error) {
+	for _, entry := range maps {
+		for _, cert := range chain {
+			if !strings.EqualFold(entry.Fingerprint, TLSFingerprint(cert)) {
+				continue
+			}
+
+			// Names are always derived from the leaf certificate
+			if name := certToName(chain[0], entry); len(name) > 0 {
+				return name, nil
+			}
+			break
+		}
+	}
+	return "", ErrNoCertToName
+}
+
+func certToName(cert *x509.Certificate, entry CertToName) string {
+	switch entry.MapType {
+	case MapSpecified:
+		return entry.Name
+	case MapSANRFC822Name:
+		if len(cert.EmailAddresses) > 0 {
+			address := strings.SplitN(cert.EmailAddresses[0], "@", 2)
+			if len(address) > 1 {
+				return address[0] + "@" + strings.ToLower(address[1])
+			}
+			return address[0]
+		}
+	case MapSANDNSName:
+		if len(cert.DNSNames) > 0 {
+			return strings.ToLower(cert.DNSNames[0])
+		}
+	case MapSANIPAddress:
+		if len(cert.IPAddresses) > 0 {
+			return cert.IPAddresses[0].String()
+		}
+	case MapSANAny:
+		if len(cert.EmailAddresses) > 0 {
+			return certToName(cert, CertToName{MapType: MapSANRFC822Name})
+		} else if len(cert.DNSNames) > 0 {
+			return certToName(cert, CertToName{MapType: MapSANDNSName})
+		} else if len(cert.IPAddresses) > 0 {
+			return certToName(cert, CertToName{MapType: MapSANIPAddress})
+		}
+	case MapCommonName:
+		return cert.Subject.CommonName
+	}
+	return ""
+}
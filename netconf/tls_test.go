@@ -0,0 +1,232 @@
+/**
+ * Copyright (c) 2019-2020 Cisco Systems
+ *
+ * Author: Steven Barth <stbarth@cisco.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package netconf
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"io"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+)
+
+// newTestCertificate creates a self-signed certificate for localhost
+func newTestCertificate(t *testing.T) (tls.Certificate, *x509.Certificate) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "device"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		DNSNames:              []string{"LocalHost"},
+		EmailAddresses:        []string{"admin@Example.com"},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, cert
+}
+
+// startTLSServer serves each accepted TLS connection with the given function and returns the client config.
+// Client certificates issued by clientCAs are required unless it is nil.
+func startTLSServer(t *testing.T, clientCAs *x509.CertPool, serve func(net.Conn)) (string, *tls.Config) {
+	certificate, cert := newTestCertificate(t)
+	serverConfig := &tls.Config{Certificates: []tls.Certificate{certificate}}
+	if clientCAs != nil {
+		serverConfig.ClientAuth, serverConfig.ClientCAs = tls.RequireAndVerifyClientCert, clientCAs
+	}
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", serverConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serve(conn)
+		}
+	}()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(cert)
+	return listener.Addr().String(), &tls.Config{RootCAs: roots, ServerName: "localhost"}
+}
+
+func TestTLS(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+	addr, config := startTLSServer(t, nil, func(conn net.Conn) { server.ServeTransport(conn) })
+
+	client, err := DialTLS(addr, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	session, err := client.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	} else if _, err := client.NewSession(); err != ErrTLSSessionInUse {
+		t.Fatalf("Expected session in use, got %v", err)
+	}
+
+	identity, err := TLSPeerIdentity(client, nil)
+	if err != nil {
+		t.Fatal(err)
+	} else if identity.Certificate.Subject.CommonName != "device" || identity.Name != "" ||
+		identity.Fingerprint != TLSFingerprint(identity.Certificate) || len(identity.Fingerprint) != 2+32*3 {
+		t.Fatalf("Unexpected identity %+v", identity)
+	}
+
+	// Entries not matching the fingerprint are skipped, names are normalized
+	other := identity.Fingerprint[:len(identity.Fingerprint)-2] + "00"
+	if other == identity.Fingerprint {
+		other = other[:len(other)-2] + "01"
+	}
+	chain := []*x509.Certificate{identity.Certificate}
+	for mapType, expected := range map[CertToNameMapType]string{
+		MapSpecified:     "operator",
+		MapSANRFC822Name: "admin@example.com",
+		MapSANDNSName:    "localhost",
+		MapSANIPAddress:  "127.0.0.1",
+		MapSANAny:        "admin@example.com",
+		MapCommonName:    "device",
+	} {
+		maps := []CertToName{
+			{Fingerprint: other, MapType: MapSpecified, Name: "other"},
+			{Fingerprint: identity.Fingerprint, MapType: mapType, Name: "operator"},
+		}
+		if name, err := CertToNameLookup(chain, maps); err != nil || name != expected {
+			t.Fatalf("Map type %s: unexpected name %q: %v", mapType, name, err)
+		}
+	}
+
+	if identity, err := TLSPeerIdentity(client, []CertToName{{Fingerprint: identity.Fingerprint, MapType: MapCommonName}}); err != nil || identity.Name != "device" {
+		t.Fatalf("Unexpected identity %+v: %v", identity, err)
+	} else if _, err := TLSPeerIdentity(client, []CertToName{{Fingerprint: other, MapType: MapCommonName}}); err != ErrNoCertToName {
+		t.Fatalf("Expected no cert-to-name, got %v", err)
+	} else if err := session.CallSimple(&Lock{Target: Running}); err == nil {
+		t.Fatal("Lock without handler succeeded")
+	} else if err := session.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTLSSessionFailure(t *testing.T) {
+	closed := make(chan error, 1)
+	addr, config := startTLSServer(t, nil, func(conn net.Conn) {
+		defer conn.Close()
+		conn.Write([]byte(`<hello xmlns="urn:ietf:params:xml:ns:netconf:base:1.0"><session-id>1</session-id></hello>]]>]]>`))
+		_, err := io.ReadAll(conn)
+		closed <- err
+	})
+
+	client, err := DialTLS(addr, config)
+	if err != nil {
+		t.Fatal(err)
+	} else if _, err := client.NewSession(); err != ErrCapabilitiesExchange {
+		t.Fatalf("Expected failed capabilities exchange, got %v", err)
+	}
+
+	// The connection is closed once the session failed
+	select {
+	case err := <-closed:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("Connection not closed")
+	}
+}
+
+func TestTLSClientCertificate(t *testing.T) {
+	certificate, cert := newTestCertificate(t)
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(cert)
+
+	server := NewServer()
+	defer server.Close()
+	peers := make(chan []*x509.Certificate, 1)
+	addr, config := startTLSServer(t, clientCAs, func(conn net.Conn) {
+		tlsConn := conn.(*tls.Conn)
+		if tlsConn.Handshake() != nil {
+			conn.Close()
+			return
+		}
+		peers <- tlsConn.ConnectionState().PeerCertificates
+		server.ServeTransport(conn)
+	})
+
+	// The client certificate is verified by the server
+	client, err := DialTLSWithCertificate(addr, certificate, config.RootCAs, "localhost")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if session, err := client.NewSession(); err != nil {
+		t.Fatal(err)
+	} else if err := session.Close(); err != nil {
+		t.Fatal(err)
+	} else if chain := <-peers; len(chain) == 0 || !chain[0].Equal(cert) {
+		t.Fatalf("Unexpected client certificate chain %v", chain)
+	}
+
+	// Clients without certificate are rejected, at the latest when the session starts with TLS 1.3
+	if client, err := DialTLS(addr, config); err == nil {
+		_, err = client.NewSession()
+		client.Close()
+		if err == nil {
+			t.Fatal("Session without client certificate succeeded")
+		}
+	}
+
+	// The server certificate must match the server name
+	var hostnameErr x509.HostnameError
+	if client, err := DialTLSWithCertificate(addr, certificate, config.RootCAs, "other.example.com"); !errors.As(err, &hostnameErr) {
+		if client != nil {
+			client.Close()
+		}
+		t.Fatalf("Expected hostname error, got %v", err)
+	}
+}
@@ -0,0 +1,211 @@
+/**
+ * Copyright (c) 2019-2020 Cisco Systems
+ *
+ * Author: Steven Barth <stbarth@cisco.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package netconf
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrTransactionUnsupported indicates that the server supports neither candidate nor writable-running
+var ErrTransactionUnsupported = errors.New("Server supports neither candidate nor writable-running datastore")
+
+// ErrTransactionClosed indicates that a transaction was already committed or discarded
+var ErrTransactionClosed = errors.New("Transaction already closed")
+
+// cleanupTimeout bounds discarding and unlocking after a failed or cancelled operation
+const cleanupTimeout = 30 * time.Second
+
+// TransactionOptions defines optional parameters for configuration transactions
+type TransactionOptions struct {
+	DefaultOperation *DefaultOperation
+	TestOption       *TestOption
+	ErrorOption      *ErrorOption
+	ConfirmTimeout   *uint   // Use a confirmed commit with the given timeout in seconds, requires candidate and confirmed-commit
+	Persist          *string // Persist token for the confirmed commit, requires confirmed-commit:1.1
+}
+
+// Transaction represents a locked configuration change on a session.
+// If the server supports the candidate datastore, edits are applied to it and committed,
+// otherwise they are applied to the running datastore directly.
+type Transaction struct {
+	session *Session
+	options TransactionOptions
+	target  Datastore
+	locked  []Datastore
+	closed  bool
+}
+
+// BeginTransaction locks the datastores necessary for a configuration change.
+// ErrConfirmedCommitUnsupported is returned if a confirmed commit was requested but is not possible.
+func (s *Session) BeginTransaction(ctx context.Context, options *TransactionOptions) (*Transaction, error) {
+	t := &Transaction{session: s}
+	if options != nil {
+		t.options = *options
+	}
+
+	var locks []Datastore
+	if s.HasCapability(CapCandidate) {
+		t.target = Candidate
+		locks = []Datastore{Running, Candidate}
+	} else if s.HasCapability(CapWritableRunning) {
+		t.target = Running
+		locks = []Datastore{Running}
+		if t.options.ErrorOption == nil && s.HasCapability(CapRollbackOnError) {
+			errorOption := RollbackOnError
+			t.options.ErrorOption = &errorOption
+		}
+	} else {
+		return nil, ErrTransactionUnsupported
+	}
+
+	// Never fall back to a permanent commit if a confirmed one was requested
+	if t.options.ConfirmTimeout != nil && (t.target != Candidate || !s.HasCapability(CapConfirmedCommit, CapConfirmedCommit10)) {
+		return nil, ErrConfirmedCommitUnsupported
+	} else if t.options.Persist != nil && (t.options.ConfirmTimeout == nil || !s.HasCapability(CapConfirmedCommit)) {
+		return nil, ErrConfirmedCommitUnsupported
+	}
+
+	for _, datastore := range locks {
+		if err := s.CallSimpleContext(ctx, &Lock{Target: datastore}); err != nil {
+			cleanup, cancel := cleanupContext()
+			defer cancel()
+			t.unlock(cleanup)
+			return nil, err
+		}
+		t.locked = append(t.locked, datastore)
+	}
+	return t, nil
+}
+
+// Target returns the datastore edits are applied to
+func (t *Transaction) Target() Datastore {
+	return t.target
+}
+
+// Edit applies an <edit-config> to the target datastore, the transaction is discarded if it fails
+func (t *Transaction) Edit(ctx context.Context, config []byte) error {
+	if t.closed {
+		return ErrTransactionClosed
+	}
+
+	edit := &EditConfig{
+		Target:           t.target,
+		DefaultOperation: t.options.DefaultOperation,
+		TestOption:       t.options.TestOption,
+		ErrorOption:      t.options.ErrorOption,
+	}
+	edit.Config.InnerXML = config
+
+	if err := t.session.CallSimpleContext(ctx, edit); err != nil {
+		cleanup, cancel := cleanupContext()
+		defer cancel()
+		t.Discard(cleanup)
+		return err
+	}
+	return nil
+}
+
+// Commit validates and commits the candidate datastore and releases the locks.
+// If the commit fails the transaction is discarded.
+func (t *Transaction) Commit(ctx context.Context) error {
+	if t.closed {
+		return ErrTransactionClosed
+	} else if t.target != Candidate {
+		t.closed = true
+		return t.unlock(ctx)
+	}
+
+	s := t.session
+	var err error
+	if s.HasCapability(CapValidate, CapValidate10) {
+		err = s.CallSimpleContext(ctx, &Validate{Source: Candidate})
+	}
+
+	if err == nil {
+		if t.options.ConfirmTimeout != nil {
+			err = s.CallSimpleContext(ctx, &CommitConfirmed{
+				ConfirmTimeout: t.options.ConfirmTimeout,
+				Persist:        t.options.Persist,
+			})
+		} else {
+			err = s.CallSimpleContext(ctx, &Commit{})
+		}
+	}
+
+	if err != nil {
+		cleanup, cancel := cleanupContext()
+		defer cancel()
+		t.Discard(cleanup)
+		return err
+	}
+
+	t.closed = true
+	return t.unlock(ctx)
+}
+
+// cleanupContext returns a context for cleaning up independent of the possibly cancelled context of the operation
+func cleanupContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), cleanupTimeout)
+}
+
+// Discard reverts all changes of the candidate datastore and releases the locks
+func (t *Transaction) Discard(ctx context.Context) error {
+	if t.closed {
+		return ErrTransactionClosed
+	}
+	t.closed = true
+
+	var err error
+	if t.target == Candidate {
+		err = t.session.CallSimpleContext(ctx, &DiscardChanges{})
+	}
+	if errUnlock := t.unlock(ctx); err == nil {
+		err = errUnlock
+	}
+	return err
+}
+
+// unlock releases acquired locks in reverse order
+func (t *Transaction) unlock(ctx context.Context) error {
+	var err error
+	for i := len(t.locked) - 1; i >= 0; i-- {
+		if errUnlock := t.session.CallSimpleContext(ctx, &Unlock{Target: t.locked[i]}); err == nil {
+			err = errUnlock
+		}
+	}
+	t.locked = nil
+	return err
+}
+
+// ApplyConfig applies one or more configuration edits in a single transaction
+func (s *Session) ApplyConfig(ctx context.Context, options *TransactionOptions, configs ...[]byte) error {
+	t, err := s.BeginTransaction(ctx, options)
+	if err != nil {
+		return err
+	}
+
+	for _, config := range configs {
+		if err := t.Edit(ctx, config); err != nil {
+			return err
+		}
+	}
+	return t.Commit(ctx)
+}
@@ -0,0 +1,215 @@
+/**
+ * Copyright (c) 2019-2020 Cisco Systems
+ *
+ * Author: Steven Barth <stbarth@cisco.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package netconf
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// configServer is a server recording the configuration operations it receives
+type configServer struct {
+	*Server
+	mutex      sync.Mutex
+	operations []string
+	fail       map[string]error                       // Errors returned for operations by name
+	block      map[string]<-chan struct{}             // Operations are delayed until the channel closes
+	commits    func(commit *configCommit) (err error) // Optional hook for commits
+}
+
+// configCommit are the parameters of a received <commit>
+type configCommit struct {
+	Confirmed      *struct{} `xml:"confirmed"`
+	ConfirmTimeout string    `xml:"confirm-timeout"`
+	Persist        string    `xml:"persist"`
+	PersistID      string    `xml:"persist-id"`
+}
+
+// String formats the commit parameters for comparison
+func (c *configCommit) String() string {
+	parts := []string{"commit"}
+	if c.Confirmed != nil {
+		parts = append(parts, "confirmed")
+	}
+	if len(c.ConfirmTimeout) > 0 {
+		parts = append(parts, "timeout="+c.ConfirmTimeout)
+	}
+	if len(c.Persist) > 0 {
+		parts = append(parts, "persist="+c.Persist)
+	}
+	if len(c.PersistID) > 0 {
+		parts = append(parts, "persist-id="+c.PersistID)
+	}
+	return strings.Join(parts, " ")
+}
+
+func newConfigServer(capabilities ...string) *configServer {
+	server := &configServer{Server: NewServer(capabilities...), fail: make(map[string]error), block: make(map[string]<-chan struct{})}
+	for _, name := range []string{"lock", "unlock", "edit-config", "validate", "commit", "cancel-commit", "discard-changes"} {
+		server.Handle(xml.Name{Space: NsNetconf, Local: name}, server.handle)
+	}
+	return server
+}
+
+func (c *configServer) handle(s *ServerSession, r *ServerRequest) (interface{}, error) {
+	operation := r.Operation.Local
+	request := &struct {
+		Target Datastore `xml:"target"`
+		Source Datastore `xml:"source"`
+		configCommit
+	}{}
+	if err := r.Decode(request); err != nil {
+		return nil, err
+	}
+
+	switch operation {
+	case "lock", "unlock", "edit-config":
+		operation += " " + string(request.Target)
+	case "validate":
+		operation += " " + string(request.Source)
+	case "commit":
+		operation = request.configCommit.String()
+	case "cancel-commit":
+		if len(request.PersistID) > 0 {
+			operation += " persist-id=" + request.PersistID
+		}
+	}
+
+	c.mutex.Lock()
+	c.operations = append(c.operations, operation)
+	err, block := c.fail[r.Operation.Local], c.block[r.Operation.Local]
+	c.mutex.Unlock()
+
+	if block != nil {
+		<-block
+	}
+	return nil, err
+}
+
+// received returns and clears the operations received so far
+func (c *configServer) received() []string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	operations := c.operations
+	c.operations = nil
+	return operations
+}
+
+func checkOperations(t *testing.T, server *configServer, expected ...string) {
+	t.Helper()
+	if received := server.received(); !reflect.DeepEqual(received, expected) {
+		t.Fatalf("Unexpected operations\n%q\nexpected\n%q", received, expected)
+	}
+}
+
+func TestTransaction(t *testing.T) {
+	server := newConfigServer(CapCandidate, CapValidate)
+	session := startPipeServer(t, server.Server)
+
+	if err := session.ApplyConfig(context.Background(), nil, []byte("<top/>")); err != nil {
+		t.Fatal(err)
+	}
+	checkOperations(t, server, "lock running", "lock candidate", "edit-config candidate",
+		"validate candidate", "commit", "unlock candidate", "unlock running")
+
+	// Failed edits discard the changes and release the locks
+	server.fail["edit-config"] = TagInvalidValue
+	if err := session.ApplyConfig(context.Background(), nil, []byte("<top/>")); !errors.Is(err, TagInvalidValue) {
+		t.Fatalf("Expected invalid-value, got %v", err)
+	}
+	checkOperations(t, server, "lock running", "lock candidate", "edit-config candidate",
+		"discard-changes", "unlock candidate", "unlock running")
+	delete(server.fail, "edit-config")
+
+	// Failed commits as well
+	server.fail["commit"] = TagOperationFailed
+	if err := session.ApplyConfig(context.Background(), nil, []byte("<top/>")); !errors.Is(err, TagOperationFailed) {
+		t.Fatalf("Expected operation-failed, got %v", err)
+	}
+	checkOperations(t, server, "lock running", "lock candidate", "edit-config candidate",
+		"validate candidate", "commit", "discard-changes", "unlock candidate", "unlock running")
+	delete(server.fail, "commit")
+
+	// A confirmed commit is never replaced by a permanent one
+	timeout := uint(60)
+	if err := session.ApplyConfig(context.Background(), &TransactionOptions{ConfirmTimeout: &timeout},
+		[]byte("<top/>")); err != ErrConfirmedCommitUnsupported {
+		t.Fatalf("Expected ErrConfirmedCommitUnsupported, got %v", err)
+	}
+	checkOperations(t, server)
+}
+
+func TestTransactionConfirmed(t *testing.T) {
+	server := newConfigServer(CapCandidate, CapConfirmedCommit10)
+	session := startPipeServer(t, server.Server)
+
+	timeout, persist := uint(60), "token"
+	options := &TransactionOptions{ConfirmTimeout: &timeout}
+	if err := session.ApplyConfig(context.Background(), options, []byte("<top/>")); err != nil {
+		t.Fatal(err)
+	}
+	checkOperations(t, server, "lock running", "lock candidate", "edit-config candidate",
+		"commit confirmed timeout=60", "unlock candidate", "unlock running")
+
+	// Persist requires confirmed-commit:1.1
+	options.Persist = &persist
+	if _, err := session.BeginTransaction(context.Background(), options); err != ErrConfirmedCommitUnsupported {
+		t.Fatalf("Expected ErrConfirmedCommitUnsupported, got %v", err)
+	}
+
+	// Confirmed commits require the candidate datastore
+	server = newConfigServer(CapWritableRunning, CapConfirmedCommit)
+	session = startPipeServer(t, server.Server)
+	if _, err := session.BeginTransaction(context.Background(), &TransactionOptions{ConfirmTimeout: &timeout}); err != ErrConfirmedCommitUnsupported {
+		t.Fatalf("Expected ErrConfirmedCommitUnsupported, got %v", err)
+	}
+	checkOperations(t, server)
+}
+
+func TestTransactionCancelled(t *testing.T) {
+	server := newConfigServer(CapCandidate)
+	session := startPipeServer(t, server.Server)
+	session.Multiplex()
+
+	transaction, err := session.BeginTransaction(context.Background(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Cleanup must not use the cancelled context of the edit
+	ctx, cancel := context.WithCancel(context.Background())
+	block := make(chan struct{})
+	server.block["edit-config"] = block
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		cancel()
+		close(block)
+	}()
+	if err := transaction.Edit(ctx, []byte("<top/>")); err != context.Canceled {
+		t.Fatalf("Expected context.Canceled, got %v", err)
+	}
+	checkOperations(t, server, "lock running", "lock candidate", "edit-config candidate",
+		"discard-changes", "unlock candidate", "unlock running")
+}
@@ -0,0 +1,256 @@
+/**
+ * Copyright (c) 2019-2020 Cisco Systems
+ *
+ * Author: Steven Barth <stbarth@cisco.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package netconf
+
+import (
+	"context"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// YangLibrary models the YANG library of a server (RFC 8525), the legacy
+// modules-state (RFC 7895) is represented as a single module set without schemas
+type YangLibrary struct {
+	ModuleSets []YangModuleSet `xml:"urn:ietf:params:xml:ns:yang:ietf-yang-library module-set"`
+	Schemas    []YangSchema    `xml:"urn:ietf:params:xml:ns:yang:ietf-yang-library schema"`
+	Datastores []YangDatastore `xml:"urn:ietf:params:xml:ns:yang:ietf-yang-library datastore"`
+	ContentID  string          `xml:"urn:ietf:params:xml:ns:yang:ietf-yang-library content-id"`
+}
+
+// YangModuleSet is a set of implemented and import-only YANG modules
+type YangModuleSet struct {
+	Name              string       `xml:"urn:ietf:params:xml:ns:yang:ietf-yang-library name"`
+	Modules           []YangModule `xml:"urn:ietf:params:xml:ns:yang:ietf-yang-library module"`
+	ImportOnlyModules []YangModule `xml:"urn:ietf:params:xml:ns:yang:ietf-yang-library import-only-module"`
+}
+
+// YangModule is a YANG module of the library, features and deviations are only set if implemented
+type YangModule struct {
+	Name       string          `xml:"urn:ietf:params:xml:ns:yang:ietf-yang-library name"`
+	Revision   string          `xml:"urn:ietf:params:xml:ns:yang:ietf-yang-library revision"`
+	Namespace  string          `xml:"urn:ietf:params:xml:ns:yang:ietf-yang-library namespace"`
+	Locations  []string        `xml:"urn:ietf:params:xml:ns:yang:ietf-yang-library location"`
+	Submodules []YangSubmodule `xml:"urn:ietf:params:xml:ns:yang:ietf-yang-library submodule"`
+	Features   []string        `xml:"urn:ietf:params:xml:ns:yang:ietf-yang-library feature"`
+	Deviations []string        `xml:"urn:ietf:params:xml:ns:yang:ietf-yang-library deviation"` // Names of deviating modules
+}
+
+// YangSubmodule is a submodule of a YANG module
+type YangSubmodule struct {
+	Name      string   `xml:"urn:ietf:params:xml:ns:yang:ietf-yang-library name"`
+	Revision  string   `xml:"urn:ietf:params:xml:ns:yang:ietf-yang-library revision"`
+	Locations []string `xml:"urn:ietf:params:xml:ns:yang:ietf-yang-library location"`
+}
+
+// YangSchema is a complete schema made up of module sets
+type YangSchema struct {
+	Name       string   `xml:"urn:ietf:params:xml:ns:yang:ietf-yang-library name"`
+	ModuleSets []string `xml:"urn:ietf:params:xml:ns:yang:ietf-yang-library module-set"`
+}
+
+// YangDatastore associates a datastore with the name of its schema
+type YangDatastore struct {
+	Name   DatastoreIdentity `xml:"urn:ietf:params:xml:ns:yang:ietf-yang-library name"`
+	Schema string            `xml:"urn:ietf:params:xml:ns:yang:ietf-yang-library schema"`
+}
+
+// modulesState models the legacy RFC 7895 modules-state container
+type modulesState struct {
+	ModuleSetID string `xml:"urn:ietf:params:xml:ns:yang:ietf-yang-library module-set-id"`
+	Modules     []struct {
+		YangModule
+		Schema     string `xml:"urn:ietf:params:xml:ns:yang:ietf-yang-library schema"`
+		Deviations []struct {
+			Name string `xml:"urn:ietf:params:xml:ns:yang:ietf-yang-library name"`
+		} `xml:"urn:ietf:params:xml:ns:yang:ietf-yang-library deviation"`
+		ConformanceType string `xml:"urn:ietf:params:xml:ns:yang:ietf-yang-library conformance-type"`
+		Submodules      []struct {
+			YangSubmodule
+			Schema string `xml:"urn:ietf:params:xml:ns:yang:ietf-yang-library schema"`
+		} `xml:"urn:ietf:params:xml:ns:yang:ietf-yang-library submodule"`
+	} `xml:"urn:ietf:params:xml:ns:yang:ietf-yang-library module"`
+}
+
+// library converts modules-state into a library with a single module set
+func (state *modulesState) library() *YangLibrary {
+	set := YangModuleSet{Name: "modules-state"}
+	for _, legacy := range state.Modules {
+		module := legacy.YangModule
+		module.Deviations = nil
+		if len(legacy.Schema) > 0 {
+			module.Locations = []string{legacy.Schema}
+		}
+		for _, submodule := range legacy.Submodules {
+			if len(submodule.Schema) > 0 {
+				submodule.Locations = []string{submodule.Schema}
+			}
+			module.Submodules = append(module.Submodules, submodule.YangSubmodule)
+		}
+
+		if legacy.ConformanceType == "import" {
+			module.Features = nil
+			set.ImportOnlyModules = append(set.ImportOnlyModules, module)
+		} else {
+			for _, deviation := range legacy.Deviations {
+				module.Deviations = append(module.Deviations, deviation.Name)
+			}
+			set.Modules = append(set.Modules, module)
+		}
+	}
+	return &YangLibrary{ModuleSets: []YangModuleSet{set}, ContentID: state.ModuleSetID}
+}
+
+// Module returns the first implemented module with the given name or nil if there is none
+func (l *YangLibrary) Module(name string) *YangModule {
+	for i := range l.ModuleSets {
+		for j := range l.ModuleSets[i].Modules {
+			if module := &l.ModuleSets[i].Modules[j]; module.Name == name {
+				return module
+			}
+		}
+	}
+	return nil
+}
+
+// DatastoreModules returns the implemented modules of the schema of a datastore,
+// all implemented modules are returned for libraries without datastores
+func (l *YangLibrary) DatastoreModules(datastore DatastoreIdentity) []YangModule {
+	sets := make(map[string]bool)
+	if len(l.Datastores) == 0 {
+		for _, set := range l.ModuleSets {
+			sets[set.Name] = true
+		}
+	}
+
+	for _, ds := range l.Datastores {
+		if ds.Name != datastore {
+			continue
+		}
+		for _, schema := range l.Schemas {
+			if schema.Name == ds.Schema {
+				for _, set := range schema.ModuleSets {
+					sets[set] = true
+				}
+			}
+		}
+	}
+
+	var modules []YangModule
+	for _, set := range l.ModuleSets {
+		if sets[set.Name] {
+			modules = append(modules, set.Modules...)
+		}
+	}
+	return modules
+}
+
+// YangLibraryCache caches YANG libraries by device and content-id to share them between sessions
+type YangLibraryCache struct {
+	mutex     sync.Mutex
+	libraries map[yangLibraryKey]*YangLibrary
+}
+
+type yangLibraryKey struct {
+	device    string
+	contentID string
+}
+
+// Get returns the cached library for the device and the content-id announced by the session or retrieves it.
+// The device identifies the server of the session, e.g. its address, as content-ids are only unique per server.
+func (c *YangLibraryCache) Get(ctx context.Context, device string, session *Session) (*YangLibrary, error) {
+	contentID := session.yangLibraryContentID()
+	c.mutex.Lock()
+	library := c.libraries[yangLibraryKey{device, contentID}]
+	c.mutex.Unlock()
+	if library != nil && len(contentID) > 0 {
+		return library, nil
+	}
+
+	library, err := session.GetYangLibrary(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mutex.Lock()
+	if c.libraries == nil {
+		c.libraries = make(map[yangLibraryKey]*YangLibrary)
+	}
+	if len(library.ContentID) > 0 {
+		c.libraries[yangLibraryKey{device, library.ContentID}] = library
+	}
+	if len(contentID) > 0 {
+		c.libraries[yangLibraryKey{device, contentID}] = library
+	}
+	c.mutex.Unlock()
+	return library, nil
+}
+
+// yangLibraryContentID returns the content-id or module-set-id announced in the hello or an empty string
+func (s *Session) yangLibraryContentID() string {
+	if query, ok := s.Capabilities[CapYangLibrary11]; ok {
+		return capabilityParameter(query, "content-id")
+	} else if query, ok := s.Capabilities[CapYangLibrary]; ok {
+		return capabilityParameter(query, "module-set-id")
+	}
+	return ""
+}
+
+func capabilityParameter(query string, name string) string {
+	parameters, _ := url.ParseQuery(strings.ReplaceAll(query, "&amp;", "&"))
+	return parameters.Get(name)
+}
+
+// YangLibrary returns the YANG library of the server. It is retrieved once per session if the server
+// announced a content-id, a YangLibraryCache shares libraries between sessions.
+func (s *Session) YangLibrary(ctx context.Context) (*YangLibrary, error) {
+	return s.yangLibraries.Get(ctx, "", s)
+}
+
+// GetYangLibrary retrieves the YANG library of the server using <get>. The RFC 8525 /yang-library is used
+// if the server announced yang-library:1.1 or a recent ietf-yang-library, otherwise /modules-state.
+func (s *Session) GetYangLibrary(ctx context.Context) (*YangLibrary, error) {
+	current := s.HasCapability(CapYangLibrary11) || s.HasModule("ietf-yang-library", "2019-01-04")
+	filter := &Filter{Type: "subtree", Subtree: `<modules-state xmlns="` + NsYangLibrary + `"/>`}
+	if current {
+		filter.Subtree = `<yang-library xmlns="` + NsYangLibrary + `"/>`
+	}
+
+	reply := &struct {
+		RPCReply
+		Data struct {
+			YangLibrary  *YangLibrary  `xml:"urn:ietf:params:xml:ns:yang:ietf-yang-library yang-library"`
+			ModulesState *modulesState `xml:"urn:ietf:params:xml:ns:yang:ietf-yang-library modules-state"`
+		} `xml:"data"`
+	}{}
+	err := s.CallContext(ctx, &Get{Filter: filter}, reply)
+	if err == nil {
+		err = reply.Err()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if reply.Data.YangLibrary != nil {
+		return reply.Data.YangLibrary, nil
+	} else if reply.Data.ModulesState != nil {
+		return reply.Data.ModulesState.library(), nil
+	}
+	return nil, &MalformedReplyError{Reason: "no YANG library in data"}
+}
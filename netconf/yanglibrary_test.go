@@ -0,0 +1,181 @@
+/**
+ * Copyright (c) 2019-2020 Cisco Systems
+ *
+ * Author: Steven Barth <stbarth@cisco.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package netconf
+
+import (
+	"context"
+	"encoding/xml"
+	"net"
+	"reflect"
+	"testing"
+)
+
+const testYangLibrary = `<yang-library xmlns="urn:ietf:params:xml:ns:yang:ietf-yang-library"
+    xmlns:ds="urn:ietf:params:xml:ns:yang:ietf-datastores">
+  <module-set>
+    <name>config-modules</name>
+    <module>
+      <name>ietf-interfaces</name>
+      <revision>2018-02-20</revision>
+      <namespace>urn:ietf:params:xml:ns:yang:ietf-interfaces</namespace>
+      <feature>if-mib</feature>
+      <deviation>example-deviations</deviation>
+    </module>
+    <module>
+      <name>example</name>
+      <namespace>urn:example</namespace>
+      <location>https://example.com/example.yang</location>
+      <submodule><name>example-types</name><revision>2020-01-01</revision></submodule>
+    </module>
+    <import-only-module>
+      <name>ietf-yang-types</name>
+      <revision>2013-07-15</revision>
+      <namespace>urn:ietf:params:xml:ns:yang:ietf-yang-types</namespace>
+    </import-only-module>
+  </module-set>
+  <module-set>
+    <name>state-modules</name>
+    <module><name>ietf-hardware</name><namespace>urn:ietf:params:xml:ns:yang:ietf-hardware</namespace></module>
+  </module-set>
+  <schema><name>config-schema</name><module-set>config-modules</module-set></schema>
+  <schema><name>state-schema</name><module-set>config-modules</module-set><module-set>state-modules</module-set></schema>
+  <datastore><name>ds:running</name><schema>config-schema</schema></datastore>
+  <datastore><name>ds:operational</name><schema>state-schema</schema></datastore>
+  <content-id>14782ab9bd56b92aacc156a2958fbe12312fb285</content-id>
+</yang-library>`
+
+const testModulesState = `<modules-state xmlns="urn:ietf:params:xml:ns:yang:ietf-yang-library">
+  <module-set-id>4</module-set-id>
+  <module>
+    <name>ietf-interfaces</name>
+    <revision>2014-05-08</revision>
+    <schema>https://example.com/ietf-interfaces.yang</schema>
+    <namespace>urn:ietf:params:xml:ns:yang:ietf-interfaces</namespace>
+    <feature>if-mib</feature>
+    <deviation><name>example-deviations</name><revision>2020-01-01</revision></deviation>
+    <conformance-type>implement</conformance-type>
+  </module>
+  <module>
+    <name>ietf-yang-types</name>
+    <revision>2013-07-15</revision>
+    <namespace>urn:ietf:params:xml:ns:yang:ietf-yang-types</namespace>
+    <conformance-type>import</conformance-type>
+  </module>
+</modules-state>`
+
+// startPipeServer creates a session to the server over an in-memory connection
+func startPipeServer(t *testing.T, server *Server) *Session {
+	client, conn := net.Pipe()
+	go server.ServeTransport(conn)
+	session, err := NewSessionTransport(context.Background(), client, SessionOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		session.Close()
+		server.Close()
+	})
+	return session
+}
+
+func TestYangLibrary(t *testing.T) {
+	gets := 0
+	server := NewServer(CapYangLibrary11 + "?revision=2019-01-04&content-id=14782ab9bd56b92aacc156a2958fbe12312fb285")
+	server.Handle(xml.Name{Space: NsNetconf, Local: "get"}, func(s *ServerSession, r *ServerRequest) (interface{}, error) {
+		gets++
+		return &DataContent{InnerXML: []byte(testYangLibrary)}, nil
+	})
+	session := startPipeServer(t, server)
+
+	library, err := session.YangLibrary(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	} else if cached, err := session.YangLibrary(context.Background()); err != nil || cached != library || gets != 1 {
+		t.Fatalf("Library not cached: %v", err)
+	}
+
+	if len(library.ModuleSets) != 2 || len(library.Schemas) != 2 || library.ContentID != "14782ab9bd56b92aacc156a2958fbe12312fb285" {
+		t.Fatalf("Unexpected library %+v", library)
+	} else if library.Datastores[1].Name != DatastoreOperational || library.Datastores[1].Schema != "state-schema" {
+		t.Fatalf("Unexpected datastore %+v", library.Datastores[1])
+	} else if library.ModuleSets[0].ImportOnlyModules[0].Name != "ietf-yang-types" {
+		t.Fatalf("Unexpected import-only modules %+v", library.ModuleSets[0].ImportOnlyModules)
+	}
+
+	expected := &YangModule{
+		Name:       "example",
+		Namespace:  "urn:example",
+		Locations:  []string{"https://example.com/example.yang"},
+		Submodules: []YangSubmodule{{Name: "example-types", Revision: "2020-01-01"}},
+	}
+	if module := library.Module("example"); !reflect.DeepEqual(module, expected) {
+		t.Fatalf("Unexpected module %+v", module)
+	} else if module := library.Module("ietf-interfaces"); module.Features[0] != "if-mib" || module.Deviations[0] != "example-deviations" {
+		t.Fatalf("Unexpected module %+v", module)
+	} else if len(library.DatastoreModules(DatastoreRunning)) != 2 || len(library.DatastoreModules(DatastoreOperational)) != 3 {
+		t.Fatal("Unexpected datastore modules")
+	}
+}
+
+func TestYangLibraryModulesState(t *testing.T) {
+	server := NewServer(CapYangLibrary + "?revision=2016-06-21&amp;module-set-id=4")
+	server.Handle(xml.Name{Space: NsNetconf, Local: "get"}, func(s *ServerSession, r *ServerRequest) (interface{}, error) {
+		request := &Get{}
+		if err := r.Decode(request); err != nil {
+			return nil, err
+		} else if request.Filter == nil || request.Filter.Subtree != `<modules-state xmlns="`+NsYangLibrary+`"/>` {
+			t.Errorf("Unexpected filter %+v", request.Filter)
+		}
+		return &DataContent{InnerXML: []byte(testModulesState)}, nil
+	})
+
+	// Libraries are shared between sessions to the same device only
+	cache := &YangLibraryCache{}
+	library, err := cache.Get(context.Background(), "device", startPipeServer(t, server))
+	if err != nil {
+		t.Fatal(err)
+	} else if cached, err := cache.Get(context.Background(), "device", startPipeServer(t, server)); err != nil || cached != library {
+		t.Fatalf("Library not cached: %v", err)
+	} else if other, err := cache.Get(context.Background(), "other", startPipeServer(t, server)); err != nil || other == library {
+		t.Fatalf("Library of other device shared: %v", err)
+	}
+
+	expected := &YangLibrary{
+		ModuleSets: []YangModuleSet{{
+			Name: "modules-state",
+			Modules: []YangModule{{
+				Name:       "ietf-interfaces",
+				Revision:   "2014-05-08",
+				Namespace:  "urn:ietf:params:xml:ns:yang:ietf-interfaces",
+				Locations:  []string{"https://example.com/ietf-interfaces.yang"},
+				Features:   []string{"if-mib"},
+				Deviations: []string{"example-deviations"},
+			}},
+			ImportOnlyModules: []YangModule{{
+				Name:      "ietf-yang-types",
+				Revision:  "2013-07-15",
+				Namespace: "urn:ietf:params:xml:ns:yang:ietf-yang-types",
+			}},
+		}},
+		ContentID: "4",
+	}
+	if !reflect.DeepEqual(library, expected) {
+		t.Fatalf("Unexpected library %+v", library)
+	}
+}
@@ -0,0 +1,216 @@
+/**
+ * Copyright (c) 2019-2020 Cisco Systems
+ *
+ * Author: Steven Barth <stbarth@cisco.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package netconf
+
+import (
+	"encoding/xml"
+	"math"
+	"time"
+)
+
+// ChangeType specifies a type of change for on-change subscriptions (RFC 8641)
+type ChangeType string
+
+// List of change types for excluded-change
+const (
+	ChangeCreate  ChangeType = "create"
+	ChangeDelete  ChangeType = "delete"
+	ChangeInsert  ChangeType = "insert"
+	ChangeMove    ChangeType = "move"
+	ChangeReplace ChangeType = "replace"
+)
+
+// Periodic defines the periodic update trigger of a YANG-Push subscription
+type Periodic struct {
+	Period     uint32     `xml:"urn:ietf:params:xml:ns:yang:ietf-yang-push period"` // centiseconds
+	AnchorTime *time.Time `xml:"urn:ietf:params:xml:ns:yang:ietf-yang-push anchor-time,omitempty"`
+}
+
+// OnChange defines the on-change update trigger of a YANG-Push subscription
+type OnChange struct {
+	DampeningPeriod *uint32      `xml:"urn:ietf:params:xml:ns:yang:ietf-yang-push dampening-period,omitempty"` // centiseconds
+	SyncOnStart     *bool        `xml:"urn:ietf:params:xml:ns:yang:ietf-yang-push sync-on-start,omitempty"`
+	ExcludedChange  []ChangeType `xml:"urn:ietf:params:xml:ns:yang:ietf-yang-push excluded-change,omitempty"`
+}
+
+// centiseconds converts a duration rounding up so that short non-zero periods do not become 0
+func centiseconds(d time.Duration) uint32 {
+	const centisecond = 10 * time.Millisecond
+	if d <= 0 {
+		return 0
+	} else if d > math.MaxUint32*centisecond {
+		return math.MaxUint32
+	}
+	return uint32((d + centisecond - 1) / centisecond)
+}
+
+// NewPeriodicSubscription creates an <establish-subscription> request for a periodic YANG-Push subscription.
+// The period is rounded up to full centiseconds.
+func NewPeriodicSubscription(datastore DatastoreIdentity, xpath string, period time.Duration) *EstablishSubscription {
+	return &EstablishSubscription{
+		Datastore:            &datastore,
+		DatastoreXPathFilter: &xpath,
+		Periodic:             &Periodic{Period: centiseconds(period)},
+	}
+}
+
+// NewOnChangeSubscription creates an <establish-subscription> request for an on-change YANG-Push subscription.
+// The dampening period is rounded up to full centiseconds.
+func NewOnChangeSubscription(datastore DatastoreIdentity, xpath string, dampening time.Duration) *EstablishSubscription {
+	dampeningPeriod := centiseconds(dampening)
+	return &EstablishSubscription{
+		Datastore:            &datastore,
+		DatastoreXPathFilter: &xpath,
+		OnChange:             &OnChange{DampeningPeriod: &dampeningPeriod},
+	}
+}
+
+// PushUpdate models a <push-update> notification of a YANG-Push subscription.
+// The legacy datastore-contents-xml element used by some devices is accepted as well.
+type PushUpdate struct {
+	EventTime  time.Time
+	ID         uint32
+	Incomplete bool
+	Contents   []byte // Inner XML of datastore-contents
+}
+
+// UnmarshalXML a <notification> containing a <push-update>
+func (u *PushUpdate) UnmarshalXML(decoder *xml.Decoder, start xml.StartElement) error {
+	notification := &struct {
+		EventTime  time.Time `xml:"eventTime"`
+		PushUpdate *struct {
+			ID         uint32    `xml:"id"`
+			Incomplete *struct{} `xml:"incomplete-update"`
+			Contents   *struct {
+				InnerXML []byte `xml:",innerxml"`
+			} `xml:"datastore-contents"`
+			ContentsXML *struct {
+				InnerXML []byte `xml:",innerxml"`
+			} `xml:"datastore-contents-xml"`
+		} `xml:"urn:ietf:params:xml:ns:yang:ietf-yang-push push-update"`
+	}{}
+	if err := decoder.DecodeElement(notification, &start); err != nil {
+		return err
+	} else if notification.PushUpdate == nil {
+		return xml.UnmarshalError("Notification does not contain a push-update")
+	}
+
+	update := notification.PushUpdate
+	*u = PushUpdate{EventTime: notification.EventTime, ID: update.ID, Incomplete: update.Incomplete != nil}
+	if update.Contents != nil {
+		u.Contents = update.Contents.InnerXML
+	} else if update.ContentsXML != nil {
+		u.Contents = update.ContentsXML.InnerXML
+	}
+	return nil
+}
+
+// YangPatchOperation specifies the operation of a YANG Patch edit (RFC 8072)
+type YangPatchOperation string
+
+// List of YANG Patch operations
+const (
+	PatchCreate  YangPatchOperation = "create"
+	PatchDelete  YangPatchOperation = "delete"
+	PatchInsert  YangPatchOperation = "insert"
+	PatchMerge   YangPatchOperation = "merge"
+	PatchMove    YangPatchOperation = "move"
+	PatchReplace YangPatchOperation = "replace"
+	PatchRemove  YangPatchOperation = "remove"
+)
+
+// YangPatchEdit describes a single edit of a YANG Patch
+type YangPatchEdit struct {
+	EditID    string             `xml:"edit-id"`
+	Operation YangPatchOperation `xml:"operation"`
+	Target    string             `xml:"target"`
+	Point     string             `xml:"point"`
+	Where     string             `xml:"where"`
+	Value     *struct {
+		InnerXML []byte `xml:",innerxml"`
+	} `xml:"value"`
+}
+
+// DecodeValue decodes the first element of the edit's value into v
+func (e *YangPatchEdit) DecodeValue(v interface{}) error {
+	if e.Value == nil {
+		return xml.UnmarshalError("YANG Patch edit has no value")
+	}
+	return xml.Unmarshal(e.Value.InnerXML, v)
+}
+
+// PushChangeUpdate models a <push-change-update> notification of an on-change YANG-Push subscription
+type PushChangeUpdate struct {
+	EventTime  time.Time
+	ID         uint32
+	Incomplete bool
+	PatchID    string
+	Comment    string
+	Edits      []YangPatchEdit
+}
+
+// UnmarshalXML a <notification> containing a <push-change-update>
+func (u *PushChangeUpdate) UnmarshalXML(decoder *xml.Decoder, start xml.StartElement) error {
+	notification := &struct {
+		EventTime        time.Time `xml:"eventTime"`
+		PushChangeUpdate *struct {
+			ID         uint32    `xml:"id"`
+			Incomplete *struct{} `xml:"incomplete-update"`
+			YangPatch  struct {
+				PatchID string          `xml:"patch-id"`
+				Comment string          `xml:"comment"`
+				Edits   []YangPatchEdit `xml:"edit"`
+			} `xml:"datastore-changes>yang-patch"`
+		} `xml:"urn:ietf:params:xml:ns:yang:ietf-yang-push push-change-update"`
+	}{}
+	if err := decoder.DecodeElement(notification, &start); err != nil {
+		return err
+	} else if notification.PushChangeUpdate == nil {
+		return xml.UnmarshalError("Notification does not contain a push-change-update")
+	}
+
+	update := notification.PushChangeUpdate
+	*u = PushChangeUpdate{
+		EventTime:  notification.EventTime,
+		ID:         update.ID,
+		Incomplete: update.Incomplete != nil,
+		PatchID:    update.YangPatch.PatchID,
+		Comment:    update.YangPatch.Comment,
+		Edits:      update.YangPatch.Edits,
+	}
+	return nil
+}
+
+// ParsePushUpdate parses a <push-update> notification, returns nil for other notifications
+func ParsePushUpdate(notification *RawNotification) *PushUpdate {
+	update := &PushUpdate{}
+	if notification.Decode(update) != nil {
+		return nil
+	}
+	return update
+}
+
+// ParsePushChangeUpdate parses a <push-change-update> notification, returns nil for other notifications
+func ParsePushChangeUpdate(notification *RawNotification) *PushChangeUpdate {
+	update := &PushChangeUpdate{}
+	if notification.Decode(update) != nil {
+		return nil
+	}
+	return update
+}
@@ -0,0 +1,91 @@
+/**
+ * Copyright (c) 2019-2020 Cisco Systems
+ *
+ * Author: Steven Barth <stbarth@cisco.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package netconf
+
+import (
+	"encoding/xml"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestYangPushPeriods(t *testing.T) {
+	for _, test := range []struct {
+		period   time.Duration
+		expected uint32
+	}{
+		{0, 0},
+		{-time.Second, 0},
+		{time.Millisecond, 1},
+		{10 * time.Millisecond, 1},
+		{11 * time.Millisecond, 2},
+		{3 * time.Second, 300},
+		{500 * 24 * time.Hour, 1<<32 - 1},
+	} {
+		if periodic := NewPeriodicSubscription(DatastoreOperational, "/", test.period); periodic.Periodic.Period != test.expected {
+			t.Errorf("Period %v: expected %d, got %d", test.period, test.expected, periodic.Periodic.Period)
+		} else if onChange := NewOnChangeSubscription(DatastoreRunning, "/", test.period); *onChange.OnChange.DampeningPeriod != test.expected {
+			t.Errorf("Dampening %v: expected %d, got %d", test.period, test.expected, *onChange.OnChange.DampeningPeriod)
+		}
+	}
+
+	data, err := xml.Marshal(NewPeriodicSubscription(DatastoreOperational, "/interfaces", 5*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	} else if !strings.Contains(string(data), `<periodic xmlns="urn:ietf:params:xml:ns:yang:ietf-yang-push"><period xmlns="urn:ietf:params:xml:ns:yang:ietf-yang-push">1</period>`) {
+		t.Fatalf("Unexpected request %s", data)
+	}
+}
+
+func TestParsePushUpdate(t *testing.T) {
+	for _, contents := range []string{"datastore-contents", "datastore-contents-xml"} {
+		notification := &RawNotification{XML: []byte(`<notification xmlns="urn:ietf:params:xml:ns:netconf:notification:1.0">` +
+			`<eventTime>2024-01-01T00:00:00Z</eventTime><push-update xmlns="urn:ietf:params:xml:ns:yang:ietf-yang-push">` +
+			`<id>7</id><` + contents + `><system xmlns="urn:example"/></` + contents + `></push-update></notification>`)}
+		if update := ParsePushUpdate(notification); update == nil || update.ID != 7 || update.Incomplete ||
+			string(update.Contents) != `<system xmlns="urn:example"/>` || !update.EventTime.Equal(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)) {
+			t.Errorf("Unexpected update %+v", update)
+		} else if ParsePushChangeUpdate(notification) != nil {
+			t.Error("Push update parsed as change update")
+		}
+	}
+
+	notification := &RawNotification{XML: []byte(`<notification xmlns="urn:ietf:params:xml:ns:netconf:notification:1.0">` +
+		`<eventTime>2024-01-01T00:00:00Z</eventTime><push-change-update xmlns="urn:ietf:params:xml:ns:yang:ietf-yang-push">` +
+		`<id>8</id><incomplete-update/><datastore-changes><yang-patch><patch-id>p1</patch-id>` +
+		`<edit><edit-id>e1</edit-id><operation>replace</operation><target>/system</target>` +
+		`<value><system xmlns="urn:example"><name>a</name></system></value></edit></yang-patch></datastore-changes>` +
+		`</push-change-update></notification>`)}
+	update := ParsePushChangeUpdate(notification)
+	if update == nil || update.ID != 8 || !update.Incomplete || update.PatchID != "p1" || len(update.Edits) != 1 {
+		t.Fatalf("Unexpected change update %+v", update)
+	} else if edit := update.Edits[0]; edit.Operation != PatchReplace || edit.Target != "/system" {
+		t.Fatalf("Unexpected edit %+v", edit)
+	} else if ParsePushUpdate(notification) != nil {
+		t.Fatal("Change update parsed as push update")
+	}
+
+	value := &struct {
+		XMLName xml.Name `xml:"urn:example system"`
+		Name    string   `xml:"name"`
+	}{}
+	if err := update.Edits[0].DecodeValue(value); err != nil || value.Name != "a" {
+		t.Fatalf("Unexpected value %+v: %v", value, err)
+	}
+}